@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"time"
 
 	"github.com/yapingcat/rtsp"
 )
@@ -28,14 +31,13 @@ func main() {
 	}
 	url := os.Args[1]
 	h264file := os.Args[2]
-	client := rtsp.BuildRtspClient(url)
 	os.Create(h264file)
 	f, err := os.OpenFile(h264file, os.O_RDWR, 0666)
 	if err != nil {
 		fmt.Println(err)
 	}
 
-	client.OnFrame = func(frame rtsp.Frame) {
+	onFrame := func(frame rtsp.Frame) {
 		var frametype string
 		var codectype string
 		var slicetype string
@@ -93,6 +95,35 @@ func main() {
 
 		f.Write(frame.Data)
 	}
-	client.Start()
-	select {}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	client, err := rtsp.Dial(ctx, url, &rtsp.Options{OnFrame: onFrame, Logger: rtsp.NewStdLogger(nil, rtsp.LevelInfo)})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer client.Close()
+	desc, err := client.Describe(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, track := range desc.Tracks {
+		if err := client.Setup(ctx, track.Index); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if err := client.Play(ctx); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	select {
+	case <-sig:
+	case <-client.Done():
+	}
 }
@@ -0,0 +1,235 @@
+package rtsp
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	RTCP_SR   = 200
+	RTCP_RR   = 201
+	RTCP_SDES = 202
+	RTCP_BYE  = 203
+	RTCP_XR   = 207 //rfc3611
+)
+
+// extended report block types, rfc3611 4.4 and 4.5
+const (
+	xrRRTR = 4
+	xrDLRR = 5
+)
+
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |V=2|P|    RC   |   PT=RR=201   |             length            |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                     SSRC of packet sender                     |
+// +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+// |                 SSRC_1 (SSRC of first source)                 |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// | fraction lost |       cumulative number of packets lost       |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |           extended highest sequence number received           |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                      interarrival jitter                      |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                         last SR (LSR)                         |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                   delay since last SR (DLSR)                  |
+// +=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+type reportBlock struct {
+	ssrc         uint32
+	fractionLost uint8
+	totalLost    uint32
+	highestSeq   uint32
+	jitter       uint32
+	lsr          uint32
+	dlsr         uint32
+}
+
+type receiverReport struct {
+	ssrc   uint32
+	blocks []reportBlock
+}
+
+func putUint32(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
+
+func (rr *receiverReport) encode() []byte {
+	packet := make([]byte, 8+24*len(rr.blocks))
+	packet[0] = 2<<6 | uint8(len(rr.blocks)&0x1F)
+	packet[1] = RTCP_RR
+	length := len(packet)/4 - 1
+	packet[2] = byte(length >> 8)
+	packet[3] = byte(length)
+	putUint32(packet[4:], rr.ssrc)
+	for i, block := range rr.blocks {
+		b := packet[8+24*i:]
+		putUint32(b, block.ssrc)
+		putUint32(b[4:], block.totalLost&0xFFFFFF)
+		b[4] = block.fractionLost
+		putUint32(b[8:], block.highestSeq)
+		putUint32(b[12:], block.jitter)
+		putUint32(b[16:], block.lsr)
+		putUint32(b[20:], block.dlsr)
+	}
+	return packet
+}
+
+// SDES with a single CNAME item, every compound rtcp packet must carry one
+func encodeSdesCname(ssrc uint32, cname string) []byte {
+	if len(cname) > 255 {
+		cname = cname[:255]
+	}
+	chunklen := 4 + 2 + len(cname) + 1 //end of items
+	chunklen = (chunklen + 3) &^ 3
+	packet := make([]byte, 4+chunklen)
+	packet[0] = 2<<6 | 1
+	packet[1] = RTCP_SDES
+	length := len(packet)/4 - 1
+	packet[2] = byte(length >> 8)
+	packet[3] = byte(length)
+	putUint32(packet[4:], ssrc)
+	packet[8] = 1 //CNAME
+	packet[9] = byte(len(cname))
+	copy(packet[10:], cname)
+	return packet
+}
+
+func getUint32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func timeToNtp(t time.Time) uint64 {
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return sec<<32 | frac
+}
+
+// LSR, DLSR, LRR and DLRR are the middle 32 bits of a ntp timestamp, 1/65536 seconds
+func ntpShort(ntp uint64) uint32 {
+	return uint32(ntp >> 16)
+}
+
+func shortToDuration(short uint32) time.Duration {
+	return time.Duration(uint64(short) * uint64(time.Second) >> 16)
+}
+
+// roundTrip is rfc3550 6.4.1, now - LSR - DLSR, or rfc3611 4.5 with LRR and DLRR
+func roundTrip(now time.Time, last uint32, delay uint32) (time.Duration, bool) {
+	if last == 0 {
+		return 0, false
+	}
+	rtt := ntpShort(timeToNtp(now)) - last - delay
+	if int32(rtt) < 0 {
+		return 0, false
+	}
+	return shortToDuration(rtt), true
+}
+
+type dlrrBlock struct {
+	ssrc uint32
+	lrr  uint32
+	dlrr uint32
+}
+
+// rtcpPacket is a SR, RR or XR of a compound packet, the other types are skipped
+type rtcpPacket struct {
+	pt     uint8
+	ssrc   uint32
+	ntp    uint64 //of a SR
+	blocks []reportBlock
+	dlrr   []dlrrBlock
+}
+
+func decodeReportBlocks(data []byte, count int) []reportBlock {
+	var blocks []reportBlock
+	for i := 0; i < count && len(data) >= 24*(i+1); i++ {
+		b := data[24*i:]
+		blocks = append(blocks, reportBlock{
+			ssrc:         getUint32(b),
+			fractionLost: b[4],
+			totalLost:    getUint32(b[4:]) & 0xFFFFFF,
+			highestSeq:   getUint32(b[8:]),
+			jitter:       getUint32(b[12:]),
+			lsr:          getUint32(b[16:]),
+			dlsr:         getUint32(b[20:]),
+		})
+	}
+	return blocks
+}
+
+func decodeRtcp(compound []byte) ([]rtcpPacket, error) {
+	var packets []rtcpPacket
+	for len(compound) >= 4 {
+		if compound[0]>>6 != 2 {
+			return packets, errors.New("wrong rtcp version")
+		}
+		count := int(compound[0] & 0x1F)
+		length := (int(compound[2])<<8 | int(compound[3]) + 1) * 4
+		if length > len(compound) {
+			return packets, errors.New("rtcp packet too short")
+		}
+		body := compound[4:length]
+		packet := rtcpPacket{pt: compound[1]}
+		switch packet.pt {
+		case RTCP_SR:
+			if len(body) < 24 {
+				return packets, errors.New("rtcp sender report too short")
+			}
+			packet.ssrc = getUint32(body)
+			packet.ntp = uint64(getUint32(body[4:]))<<32 | uint64(getUint32(body[8:]))
+			packet.blocks = decodeReportBlocks(body[24:], count)
+			packets = append(packets, packet)
+		case RTCP_RR:
+			if len(body) < 4 {
+				return packets, errors.New("rtcp receiver report too short")
+			}
+			packet.ssrc = getUint32(body)
+			packet.blocks = decodeReportBlocks(body[4:], count)
+			packets = append(packets, packet)
+		case RTCP_XR:
+			if len(body) < 4 {
+				return packets, errors.New("rtcp extended report too short")
+			}
+			packet.ssrc = getUint32(body)
+			blocks := body[4:]
+			for len(blocks) >= 4 {
+				blocklen := (int(blocks[2])<<8 | int(blocks[3]) + 1) * 4
+				if blocklen > len(blocks) {
+					break
+				}
+				if blocks[0] == xrDLRR {
+					for sub := blocks[4:blocklen]; len(sub) >= 12; sub = sub[12:] {
+						packet.dlrr = append(packet.dlrr, dlrrBlock{ssrc: getUint32(sub), lrr: getUint32(sub[4:]), dlrr: getUint32(sub[8:])})
+					}
+				}
+				blocks = blocks[blocklen:]
+			}
+			packets = append(packets, packet)
+		}
+		compound = compound[length:]
+	}
+	return packets, nil
+}
+
+// XR with a receiver reference time block, a server supporting rfc3611 answers
+// with a DLRR block from which the round trip time is computed
+func encodeXrRRTR(ssrc uint32, now time.Time) []byte {
+	packet := make([]byte, 20)
+	packet[0] = 2 << 6
+	packet[1] = RTCP_XR
+	packet[3] = 4
+	putUint32(packet[4:], ssrc)
+	packet[8] = xrRRTR
+	packet[11] = 2
+	ntp := timeToNtp(now)
+	putUint32(packet[12:], uint32(ntp>>32))
+	putUint32(packet[16:], uint32(ntp))
+	return packet
+}
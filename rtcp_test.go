@@ -0,0 +1,170 @@
+package rtsp
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReceiverReportRoundTrip(t *testing.T) {
+	rr := receiverReport{ssrc: 0x11223344, blocks: []reportBlock{
+		{ssrc: 0xAABBCCDD, fractionLost: 25, totalLost: 1000, highestSeq: 0x0001FFFF, jitter: 90, lsr: 0x12345678, dlsr: 0x00018000},
+		{ssrc: 1, totalLost: 0xFFFFFF},
+	}}
+	packet := rr.encode()
+	if len(packet) != 56 || packet[0] != 0x82 || packet[1] != RTCP_RR || packet[3] != 13 {
+		t.Fatalf("header % x, %d bytes", packet[:4], len(packet))
+	}
+	//a compound packet, the SDES is skipped
+	compound := append(packet, encodeSdesCname(rr.ssrc, "rtspclient")...)
+	packets, err := decodeRtcp(compound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rtcpPacket{{pt: RTCP_RR, ssrc: rr.ssrc, blocks: rr.blocks}}
+	if !reflect.DeepEqual(packets, want) {
+		t.Errorf("got %+v, want %+v", packets, want)
+	}
+
+	//24 bits of cumulative lost
+	rr.blocks = []reportBlock{{totalLost: 0x1234567}}
+	packets, _ = decodeRtcp(rr.encode())
+	if len(packets) != 1 || packets[0].blocks[0].totalLost != 0x234567 {
+		t.Errorf("got %+v", packets)
+	}
+}
+
+func TestEncodeSdesCname(t *testing.T) {
+	packet := encodeSdesCname(0x01020304, "rtspclient")
+	//the item list ends with a null octet, then padding to 32 bits
+	want := []byte{0x81, RTCP_SDES, 0x00, 0x05, 0x01, 0x02, 0x03, 0x04, 0x01, 10, 'r', 't', 's', 'p', 'c', 'l', 'i', 'e', 'n', 't', 0x00, 0x00, 0x00, 0x00}
+	if !bytes.Equal(packet, want) {
+		t.Errorf("got % x", packet)
+	}
+	if packet = encodeSdesCname(1, strings.Repeat("x", 300)); len(packet)%4 != 0 || packet[9] != 255 {
+		t.Errorf("long cname: %d bytes, length %d", len(packet), packet[9])
+	}
+}
+
+func TestDecodeSenderReport(t *testing.T) {
+	sr := []byte{
+		0x81, RTCP_SR, 0x00, 0x0C,
+		0xDE, 0xAD, 0xBE, 0xEF, //ssrc
+		0xE8, 0x00, 0x00, 0x01, 0x80, 0x00, 0x00, 0x00, //ntp
+		0x00, 0x00, 0x10, 0x00, //rtp timestamp
+		0x00, 0x00, 0x00, 0x64, 0x00, 0x01, 0x00, 0x00, //packet and octet count
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x00, 0x00, 0x02, 0x00, 0x00, 0x01, 0x00,
+		0x00, 0x00, 0x00, 0x10, 0xAB, 0xCD, 0x12, 0x34, 0x00, 0x00, 0x80, 0x00,
+	}
+	packets, err := decodeRtcp(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rtcpPacket{{pt: RTCP_SR, ssrc: 0xDEADBEEF, ntp: 0xE800000180000000, blocks: []reportBlock{
+		{ssrc: 0x01020304, fractionLost: 5, totalLost: 2, highestSeq: 256, jitter: 16, lsr: 0xABCD1234, dlsr: 0x8000},
+	}}}
+	if !reflect.DeepEqual(packets, want) {
+		t.Errorf("got %+v, want %+v", packets, want)
+	}
+}
+
+func TestDecodeExtendedReport(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 500000000, time.UTC)
+	rrtr := encodeXrRRTR(0x0A0B0C0D, now)
+	ntp := timeToNtp(now)
+	if len(rrtr) != 20 || rrtr[1] != RTCP_XR || rrtr[8] != xrRRTR || getUint32(rrtr[12:]) != uint32(ntp>>32) || getUint32(rrtr[16:]) != uint32(ntp) {
+		t.Errorf("rrtr % x", rrtr)
+	}
+	dlrr := []byte{
+		0x80, RTCP_XR, 0x00, 0x08,
+		0x11, 0x22, 0x33, 0x44,
+		xrDLRR, 0x00, 0x00, 0x06,
+		0x0A, 0x0B, 0x0C, 0x0D, 0x12, 0x34, 0x56, 0x78, 0x00, 0x01, 0x00, 0x00,
+		0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02,
+	}
+	packets, err := decodeRtcp(append(rrtr, dlrr...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rtcpPacket{
+		{pt: RTCP_XR, ssrc: 0x0A0B0C0D},
+		{pt: RTCP_XR, ssrc: 0x11223344, dlrr: []dlrrBlock{{ssrc: 0x0A0B0C0D, lrr: 0x12345678, dlrr: 0x10000}, {ssrc: 0x01010101, lrr: 1, dlrr: 2}}},
+	}
+	if !reflect.DeepEqual(packets, want) {
+		t.Errorf("got %+v, want %+v", packets, want)
+	}
+}
+
+func TestDecodeRtcpMalformed(t *testing.T) {
+	rr := (&receiverReport{ssrc: 1, blocks: []reportBlock{{ssrc: 2}}}).encode()
+	tests := []struct {
+		name    string
+		packet  []byte
+		packets int //decoded before the error
+	}{
+		{"version 1", []byte{0x41, RTCP_RR, 0x00, 0x01, 0, 0, 0, 1}, 0},
+		{"length past the end", rr[:len(rr)-4], 0},
+		{"second packet cut", append(append([]byte(nil), rr...), rr[:8]...), 1},
+		{"short sender report", []byte{0x80, RTCP_SR, 0x00, 0x01, 0, 0, 0, 1}, 0},
+		{"empty receiver report", []byte{0x80, RTCP_RR, 0x00, 0x00}, 0},
+		{"empty extended report", []byte{0x80, RTCP_XR, 0x00, 0x00}, 0},
+	}
+	for _, tt := range tests {
+		packets, err := decodeRtcp(tt.packet)
+		if err == nil || len(packets) != tt.packets {
+			t.Errorf("%s: got %d packets, %v", tt.name, len(packets), err)
+		}
+	}
+	//a report count above the blocks of the packet is not trusted
+	rr[0] |= 0x1F
+	if packets, err := decodeRtcp(rr); err != nil || len(packets[0].blocks) != 1 {
+		t.Errorf("report count 31: %+v %v", packets, err)
+	}
+}
+
+func TestNtpShort(t *testing.T) {
+	if ntp := timeToNtp(time.Unix(0, 0)); ntp != ntpEpochOffset<<32 {
+		t.Errorf("unix epoch is ntp %x", ntp)
+	}
+	if ntp := timeToNtp(time.Unix(1, 500000000)); ntp != (ntpEpochOffset+1)<<32|0x80000000 {
+		t.Errorf("1.5s is ntp %x", ntp)
+	}
+	if short := ntpShort(0x0001000280000000); short != 0x00028000 {
+		t.Errorf("short %x", short)
+	}
+	if d := shortToDuration(0x00018000); d != 1500*time.Millisecond {
+		t.Errorf("0x18000 is %v", d)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	//ntp short format wraps every 65536 seconds, the last wrap was at wrapped
+	wrapped := time.Unix(65536*40000-ntpEpochOffset, 0)
+	tests := []struct {
+		name  string
+		now   time.Time
+		sent  time.Time //of the report the peer answers, LSR or LRR
+		delay time.Duration
+		rtt   time.Duration
+		ok    bool
+	}{
+		{"rtt", wrapped.Add(time.Hour), wrapped.Add(time.Hour - 1500*time.Millisecond), time.Second, 500 * time.Millisecond, true},
+		{"wraparound", wrapped.Add(250 * time.Millisecond), wrapped.Add(-2 * time.Second), time.Second, 1250 * time.Millisecond, true},
+		{"no delay", wrapped.Add(time.Minute), wrapped.Add(time.Minute - 40*time.Millisecond), 0, 40 * time.Millisecond, true},
+		{"delay above the elapsed time", wrapped.Add(time.Hour), wrapped.Add(time.Hour - time.Second), 2 * time.Second, 0, false},
+	}
+	for _, tt := range tests {
+		last := ntpShort(timeToNtp(tt.sent))
+		delay := uint32(tt.delay * 65536 / time.Second)
+		rtt, ok := roundTrip(tt.now, last, delay)
+		//the short format has a resolution of 1/65536 second
+		if diff := rtt - tt.rtt; ok != tt.ok || diff < -50*time.Microsecond || diff > 50*time.Microsecond {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, rtt, ok, tt.rtt, tt.ok)
+		}
+	}
+	if _, ok := roundTrip(time.Now(), 0, 100); ok {
+		t.Error("a report without LSR has no round trip")
+	}
+}
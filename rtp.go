@@ -1,6 +1,9 @@
 package rtsp
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 // 0                   1                   2                   3
 // 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
@@ -25,6 +28,7 @@ type rtphdr struct {
 	timestamp  uint32
 	ssrc       uint32
 	csrc       []uint32
+	extprofile uint16
 	extensions []byte
 }
 
@@ -66,20 +70,96 @@ func (r *rtp) decode(packet []byte) error {
 		r.head.csrc[i] = uint32(packet[12+i*4])<<24 | uint32(packet[13+i*4])<<16 | uint32(packet[14+i*4])<<8 | uint32(packet[15+i*4])
 	}
 	headlen += int(r.head.csrccount) * 4
+	// 0                   1                   2                   3
+	// |      defined by profile       |           length              |
+	// |                        header extension                       |
 	if r.head.extension {
-		if len(packet) < 13 {
+		if len(packet) < headlen+4 {
 			return errors.New("no extensions")
 		}
-		count := packet[12] & 0x0F
-		if len(packet) < 4*int(count)+13 {
+		r.head.extprofile = uint16(packet[headlen])<<8 | uint16(packet[headlen+1])
+		count := int(packet[headlen+2])<<8 | int(packet[headlen+3])
+		if len(packet) < headlen+4+count*4 {
 			return errors.New("has no enough bytes")
 		}
-		r.head.extensions = packet[13 : 13+count*4]
-		headlen += int(count)*4 + 1
+		r.head.extensions = packet[headlen+4 : headlen+4+count*4]
+		headlen += 4 + count*4
 	}
 	if r.head.padding {
 		r.paddingcount = packet[len(packet)-1]
+		if int(r.paddingcount) > len(packet)-headlen {
+			return errors.New("rtp padding is too large")
+		}
 	}
 	r.payload = packet[headlen : len(packet)-int(r.paddingcount)]
 	return nil
 }
+
+const onvifReplayProfile = 0xABAC
+
+// seconds between 1900-01-01 (ntp epoch) and 1970-01-01
+const ntpEpochOffset = 2208988800
+
+// ONVIF Streaming Specification, replay header extension
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |            0xABAC             |        length=3               |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                          NTP timestamp...                     |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                          ...NTP timestamp                     |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |C|E|D|S|  mbz  |     CSeq      |           padding             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type OnvifReplayExtension struct {
+	NtpTime       time.Time
+	CleanPoint    bool
+	End           bool
+	Discontinuity bool
+	CSeq          uint8
+}
+
+func ntpToTime(ntp uint64) time.Time {
+	sec := int64(ntp>>32) - ntpEpochOffset
+	nsec := int64((ntp & 0xFFFFFFFF) * 1e9 >> 32)
+	return time.Unix(sec, nsec).UTC()
+}
+
+func (r *rtp) onvifReplayExtension() (OnvifReplayExtension, bool) {
+	var ext OnvifReplayExtension
+	if !r.head.extension || r.head.extprofile != onvifReplayProfile || len(r.head.extensions) < 12 {
+		return ext, false
+	}
+	data := r.head.extensions
+	var ntp uint64
+	for i := 0; i < 8; i++ {
+		ntp = ntp<<8 | uint64(data[i])
+	}
+	ext.NtpTime = ntpToTime(ntp)
+	ext.CleanPoint = int2bool(data[8] & 0x80)
+	ext.End = int2bool(data[8] & 0x40)
+	ext.Discontinuity = int2bool(data[8] & 0x20)
+	ext.CSeq = data[9]
+	return ext, true
+}
+
+func (r *rtp) encode() []byte {
+	packet := make([]byte, 12, 12+len(r.payload))
+	packet[0] = 2 << 6
+	packet[1] = r.head.pt & 0x7F
+	if r.head.mark {
+		packet[1] |= 0x80
+	}
+	packet[2] = byte(r.head.seqnum >> 8)
+	packet[3] = byte(r.head.seqnum)
+	packet[4] = byte(r.head.timestamp >> 24)
+	packet[5] = byte(r.head.timestamp >> 16)
+	packet[6] = byte(r.head.timestamp >> 8)
+	packet[7] = byte(r.head.timestamp)
+	packet[8] = byte(r.head.ssrc >> 24)
+	packet[9] = byte(r.head.ssrc >> 16)
+	packet[10] = byte(r.head.ssrc >> 8)
+	packet[11] = byte(r.head.ssrc)
+	return append(packet, r.payload...)
+}
@@ -0,0 +1,83 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// replayPacket is a rtp packet of a recording with csrcs and an extension
+// header of profile and words
+func replayPacket(csrcs int, profile uint16, words []byte) []byte {
+	packet := []byte{0x80 | byte(csrcs), 96, 0x12, 0x34, 0x00, 0x01, 0x5F, 0x90, 0xDE, 0xAD, 0xBE, 0xEF}
+	for i := 0; i < csrcs; i++ {
+		packet = append(packet, 0, 0, 0, byte(i+1))
+	}
+	if words != nil {
+		packet[0] |= 0x10
+		packet = append(packet, byte(profile>>8), byte(profile), 0, byte(len(words)/4))
+		packet = append(packet, words...)
+	}
+	return append(packet, 0x65, 0x88)
+}
+
+func TestOnvifReplayExtension(t *testing.T) {
+	ntpTime := time.Date(2024, 1, 2, 3, 4, 5, 500000000, time.UTC)
+	ntp := timeToNtp(ntpTime)
+	replay := func(flags byte, cseq byte) []byte {
+		words := make([]byte, 12)
+		putUint32(words, uint32(ntp>>32))
+		putUint32(words[4:], uint32(ntp))
+		words[8], words[9] = flags, cseq
+		return words
+	}
+	tests := []struct {
+		name   string
+		packet []byte
+		want   OnvifReplayExtension
+		ok     bool
+	}{
+		{"clean point", replayPacket(0, onvifReplayProfile, replay(0x80, 7)),
+			OnvifReplayExtension{NtpTime: ntpTime, CleanPoint: true, CSeq: 7}, true},
+		{"end and discontinuity", replayPacket(0, onvifReplayProfile, replay(0x60, 255)),
+			OnvifReplayExtension{NtpTime: ntpTime, End: true, Discontinuity: true, CSeq: 255}, true},
+		{"after csrcs", replayPacket(2, onvifReplayProfile, replay(0xE0, 1)),
+			OnvifReplayExtension{NtpTime: ntpTime, CleanPoint: true, End: true, Discontinuity: true, CSeq: 1}, true},
+		{"other profile", replayPacket(0, 0xBEDE, replay(0x80, 7)), OnvifReplayExtension{}, false},
+		{"short extension", replayPacket(0, onvifReplayProfile, replay(0x80, 7)[:8]), OnvifReplayExtension{}, false},
+		{"no extension", replayPacket(0, 0, nil), OnvifReplayExtension{}, false},
+	}
+	for _, tt := range tests {
+		var r rtp
+		if err := r.decode(tt.packet); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		ext, ok := r.onvifReplayExtension()
+		if ok != tt.ok || ext != tt.want {
+			t.Errorf("%s: got %+v %v, want %+v %v", tt.name, ext, ok, tt.want, tt.ok)
+		}
+		if !bytes.Equal(r.payload, []byte{0x65, 0x88}) || r.head.timestamp != 90000 || r.head.seqnum != 0x1234 {
+			t.Errorf("%s: payload % x ts %d seq %x", tt.name, r.payload, r.head.timestamp, r.head.seqnum)
+		}
+	}
+
+	//the extension length runs past the end of the packet
+	packet := replayPacket(0, onvifReplayProfile, replay(0x80, 7))
+	var r rtp
+	if err := r.decode(packet[:12+4+8]); err == nil {
+		t.Error("cut extension decoded")
+	}
+}
+
+func TestNtpToTime(t *testing.T) {
+	for _, tm := range []time.Time{
+		time.Unix(0, 0).UTC(),
+		time.Date(2024, 1, 2, 3, 4, 5, 250000000, time.UTC),
+		time.Date(2036, 2, 7, 6, 28, 15, 0, time.UTC), //the last second of ntp era 0
+	} {
+		if got := ntpToTime(timeToNtp(tm)); got.Sub(tm) > time.Microsecond || tm.Sub(got) > time.Microsecond {
+			t.Errorf("%v is %v", tm, got)
+		}
+	}
+}
@@ -3,7 +3,8 @@ package rtsp
 import (
 	"bytes"
 	"errors"
-	"fmt"
+	"math/rand"
+	"strings"
 )
 
 type RtpProfile int
@@ -16,14 +17,15 @@ const (
 type payload interface {
 	decode([]byte) error
 	encode([]byte) error
-	setOnPacket(onpacket func(data []byte, timestamp uint32))
+	setOnPacket(onpacket func(data []byte, timestamp uint32, marker bool))
+	reset()
 }
 
 type h264RtpPayload struct {
 	cache_ bytes.Buffer
 	//if current rtp pakcet of frame has been losted, different timestamp means different frame
 	//lastTimestamp help to split frame
-	onPacket func(data []byte, timestamp uint32)
+	onPacket func(data []byte, timestamp uint32, marker bool)
 }
 
 func newH264Payload() *h264RtpPayload {
@@ -34,7 +36,7 @@ func newH264Payload() *h264RtpPayload {
 
 type h265RtpPayload struct {
 	cache_   bytes.Buffer
-	onPacket func(data []byte, timestamp uint32)
+	onPacket func(data []byte, timestamp uint32, marker bool)
 }
 
 func newH265Payload() *h265RtpPayload {
@@ -65,6 +67,23 @@ func createRtpPayloadByName(name string) (payload, error) {
 	}
 }
 
+// codecByName maps the encoding name of a rtpmap, which is case-insensitive
+func codecByName(name string) Codec {
+	switch strings.ToUpper(name) {
+	case "H264":
+		return H264
+	case "H265":
+		return H265
+	case "PCMA":
+		return G711A
+	case "PCMU":
+		return G711U
+	case "MPEG4-GENERIC":
+		return AAC
+	}
+	return UNSupport
+}
+
 func (h264 *h264RtpPayload) decode(packet []byte) error {
 	var rtppacket rtp
 	err := rtppacket.decode(packet)
@@ -77,13 +96,13 @@ func (h264 *h264RtpPayload) decode(packet []byte) error {
 	case payloadType >= 1 && payloadType <= 23:
 		h264.cache_.Write(rtppacket.payload)
 		if h264.onPacket != nil {
-			h264.onPacket(h264.cache_.Bytes(), rtppacket.head.timestamp)
+			h264.onPacket(h264.cache_.Bytes(), rtppacket.head.timestamp, rtppacket.head.mark)
 		}
 		h264.cache_.Truncate(4)
 	case payloadType == 28:
-		return h264.decodeFu(rtppacket.payload, rtppacket.head.timestamp, false)
+		return h264.decodeFu(rtppacket.payload, rtppacket.head.timestamp, rtppacket.head.mark, false)
 	case payloadType == 29:
-		return h264.decodeFu(rtppacket.payload, rtppacket.head.timestamp, true)
+		return h264.decodeFu(rtppacket.payload, rtppacket.head.timestamp, rtppacket.head.mark, true)
 	default:
 		return errors.New("unsupport packet type")
 	}
@@ -95,7 +114,7 @@ func (h264 *h264RtpPayload) decode(packet []byte) error {
 // +-+-+-+-+-+-+-+-+
 // |S|E|R|  Type   |
 // +---------------+
-func (h264 *h264RtpPayload) decodeFu(packet []byte, timestamp uint32, fu_b bool) error {
+func (h264 *h264RtpPayload) decodeFu(packet []byte, timestamp uint32, marker bool, fu_b bool) error {
 	fuheader := packet[1]
 	var prefixLen int = 0
 	if fu_b {
@@ -107,16 +126,15 @@ func (h264 *h264RtpPayload) decodeFu(packet []byte, timestamp uint32, fu_b bool)
 	endbit := int2bool(fuheader & 0x40)
 	if startbit {
 		if h264.cache_.Len() > 4 {
-			fmt.Println("somthing wrong happend maybe packet lost,discard dirty frame")
+			packageLogger().Debug("fragment lost, discard dirty frame")
 			h264.cache_.Truncate(4)
 		}
 		h264.cache_.WriteByte((packet[0] & 0xE0) | (packet[1] & 0x1F))
 	}
 	h264.cache_.Write(packet[prefixLen:])
-	//fmt.Printf("cache buf len %d\n", h264.cache_.Len())
 	if endbit {
 		if h264.onPacket != nil {
-			h264.onPacket(h264.cache_.Bytes(), timestamp)
+			h264.onPacket(h264.cache_.Bytes(), timestamp, marker)
 		}
 		h264.cache_.Truncate(4)
 	}
@@ -127,7 +145,11 @@ func (h264 *h264RtpPayload) encode([]byte) error {
 	return nil
 }
 
-func (h264 *h264RtpPayload) setOnPacket(onpacket func(data []byte, timestamp uint32)) {
+func (h264 *h264RtpPayload) reset() {
+	h264.cache_.Truncate(4)
+}
+
+func (h264 *h264RtpPayload) setOnPacket(onpacket func(data []byte, timestamp uint32, marker bool)) {
 	h264.onPacket = onpacket
 }
 
@@ -139,18 +161,17 @@ func (h265 *h265RtpPayload) decode(packet []byte) error {
 	}
 
 	payloadType := rtppacket.payload[0] >> 1 & 0x3F
-	//fmt.Printf("payload type %d\n", payloadType)
 	switch payloadType {
 	case 48:
 		return h265.decodeAP(rtppacket.payload, rtppacket.head.timestamp)
 	case 49:
-		return h265.decodeFu(rtppacket.payload, rtppacket.head.timestamp)
+		return h265.decodeFu(rtppacket.payload, rtppacket.head.timestamp, rtppacket.head.mark)
 	case 50:
 		return h265.decodePACI(rtppacket.payload, rtppacket.head.timestamp)
 	default:
 		h265.cache_.Write(rtppacket.payload)
 		if h265.onPacket != nil {
-			h265.onPacket(h265.cache_.Bytes(), rtppacket.head.timestamp)
+			h265.onPacket(h265.cache_.Bytes(), rtppacket.head.timestamp, rtppacket.head.mark)
 		}
 		h265.cache_.Truncate(4)
 	}
@@ -166,7 +187,7 @@ func (h265 *h265RtpPayload) decodeAP(packet []byte, timestamp uint32) error {
 // +-+-+-+-+-+-+-+-+
 // |S|E|  FuType   |
 // +---------------+
-func (h265 *h265RtpPayload) decodeFu(packet []byte, timestamp uint32) error {
+func (h265 *h265RtpPayload) decodeFu(packet []byte, timestamp uint32, marker bool) error {
 	fuheader := packet[2]
 	var prefixLen int = 0
 	prefixLen = 3
@@ -174,7 +195,7 @@ func (h265 *h265RtpPayload) decodeFu(packet []byte, timestamp uint32) error {
 	endbit := int2bool(fuheader & 0x40)
 	if startbit {
 		if h265.cache_.Len() > 4 {
-			fmt.Println("somthing wrong happend maybe packet lost,discard dirty frame")
+			packageLogger().Debug("fragment lost, discard dirty frame")
 			h265.cache_.Truncate(4)
 		}
 
@@ -183,10 +204,9 @@ func (h265 *h265RtpPayload) decodeFu(packet []byte, timestamp uint32) error {
 	}
 
 	h265.cache_.Write(packet[prefixLen:])
-	//fmt.Printf("cache buf len %d\n", h264.cache_.Len())
 	if endbit {
 		if h265.onPacket != nil {
-			h265.onPacket(h265.cache_.Bytes(), timestamp)
+			h265.onPacket(h265.cache_.Bytes(), timestamp, marker)
 		}
 		h265.cache_.Truncate(4)
 	}
@@ -201,6 +221,151 @@ func (h265 *h265RtpPayload) encode([]byte) error {
 	return nil
 }
 
-func (h265 *h265RtpPayload) setOnPacket(onpacket func(data []byte, timestamp uint32)) {
+func (h265 *h265RtpPayload) reset() {
+	h265.cache_.Truncate(4)
+}
+
+func (h265 *h265RtpPayload) setOnPacket(onpacket func(data []byte, timestamp uint32, marker bool)) {
 	h265.onPacket = onpacket
 }
+
+const maxRtpPayloadSize = 1400
+
+// packs codec frames into rtp packets
+type rtpPacker struct {
+	pt     uint8
+	seq    uint16
+	ssrc   uint32
+	codec  Codec
+	aac    AACParams //the AU header layout of the negotiated fmtp
+	onRtp  func(packet []byte) error
+	packet rtp
+}
+
+// defaultAACParams is used when the server sends no fmtp for mpeg4-generic
+var defaultAACParams = AACParams{Mode: "AAC-hbr", SizeLength: 13, IndexLength: 3, IndexDeltaLength: 3}
+
+// aacPackable accepts the AAC-hbr and AAC-lbr modes of rfc3640 3.3.5 and 3.3.6
+// and the fmtps with the same AU header fields
+func aacPackable(aac AACParams) error {
+	if !strings.EqualFold(aac.Mode, "AAC-hbr") && !strings.EqualFold(aac.Mode, "AAC-lbr") {
+		return errors.New("unsupport aac mode " + aac.Mode)
+	}
+	if aac.SizeLength <= 0 || aac.SizeLength > 16 || aac.IndexLength < 0 || aac.IndexLength > 16 {
+		return errors.New("unsupport aac au header size")
+	}
+	return nil
+}
+
+// auHeaderSection is AU-headers-length and one AU-header: AU-size, AU-Index
+// zero and the CTS-flag and DTS-flag, zero for the first AU, if there are deltas
+func (aac AACParams) auHeaderSection(size int) []byte {
+	bits := aac.SizeLength + aac.IndexLength
+	header := uint64(size) << uint(aac.IndexLength)
+	if aac.CTSDeltaLength > 0 {
+		bits++
+		header <<= 1
+	}
+	if aac.DTSDeltaLength > 0 {
+		bits++
+		header <<= 1
+	}
+	length := (bits + 7) / 8
+	header <<= uint(length*8 - bits)
+	section := []byte{byte(bits >> 8), byte(bits)}
+	for i := length - 1; i >= 0; i-- {
+		section = append(section, byte(header>>uint(i*8)))
+	}
+	return section
+}
+
+func newRtpPacker(codec Codec, pt uint8) *rtpPacker {
+	return &rtpPacker{
+		pt:    pt,
+		seq:   uint16(rand.Uint32()),
+		ssrc:  rand.Uint32(),
+		codec: codec,
+	}
+}
+
+func (p *rtpPacker) send(payload []byte, timestamp uint32, mark bool) error {
+	p.packet.head = rtphdr{pt: p.pt, mark: mark, seqnum: p.seq, timestamp: timestamp, ssrc: p.ssrc}
+	p.packet.payload = payload
+	p.seq++
+	if p.onRtp == nil {
+		return nil
+	}
+	return p.onRtp(p.packet.encode())
+}
+
+func (p *rtpPacker) pack(frame []byte, timestamp uint32) error {
+	switch p.codec {
+	case G711A, G711U:
+		return p.packG711(frame, timestamp)
+	case AAC:
+		return p.packAAC(frame, timestamp)
+	default:
+		return errors.New("unsupport codec for rtp packer")
+	}
+}
+
+// rfc3551 4.5.14, one byte per sample
+func (p *rtpPacker) packG711(frame []byte, timestamp uint32) error {
+	for len(frame) > 0 {
+		size := len(frame)
+		if size > maxRtpPayloadSize {
+			size = maxRtpPayloadSize
+		}
+		if err := p.send(frame[:size], timestamp, false); err != nil {
+			return err
+		}
+		frame = frame[size:]
+		timestamp += uint32(size)
+	}
+	return nil
+}
+
+// rfc3640 with the AU header of the fmtp, AAC-hbr by default
+// +---------+-----------+-----------+---------------+
+// | RTP     | AU Header | Auxiliary | Access Unit   |
+// | Header  | Section   | Section   | Data Section  |
+// +---------+-----------+-----------+---------------+
+func (p *rtpPacker) packAAC(frame []byte, timestamp uint32) error {
+	//strip adts header
+	if len(frame) > 7 && frame[0] == 0xFF && frame[1]&0xF0 == 0xF0 {
+		hdrlen := 7
+		if frame[1]&0x01 == 0 {
+			hdrlen = 9
+		}
+		if len(frame) <= hdrlen {
+			return errors.New("adts frame too short")
+		}
+		frame = frame[hdrlen:]
+	}
+	aac := p.aac
+	if aac.SizeLength == 0 {
+		aac = defaultAACParams
+	}
+	if len(frame) >= 1<<uint(aac.SizeLength) {
+		return errors.New("aac frame too large")
+	}
+	auhdr := aac.auHeaderSection(len(frame))
+	//an AU is fragmented only in AAC-hbr, 3.3.5
+	if len(auhdr)+len(frame) > maxRtpPayloadSize && !strings.EqualFold(aac.Mode, "AAC-hbr") {
+		return errors.New("aac frame too large")
+	}
+	for len(frame) > 0 {
+		size := len(frame)
+		if size > maxRtpPayloadSize-len(auhdr) {
+			size = maxRtpPayloadSize - len(auhdr)
+		}
+		payload := make([]byte, 0, len(auhdr)+size)
+		payload = append(payload, auhdr...)
+		payload = append(payload, frame[:size]...)
+		frame = frame[size:]
+		if err := p.send(payload, timestamp, len(frame) == 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
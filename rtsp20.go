@@ -0,0 +1,244 @@
+package rtsp
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+)
+
+// Notify-Reason of PLAY_NOTIFY, rfc7826 18.32
+const (
+	NotifyEndOfStream           = "end-of-stream"
+	NotifyMediaPropertiesUpdate = "media-properties-update"
+	NotifyScaleChange           = "scale-change"
+)
+
+// PlayNotify is sent by a rtsp 2.0 server when the state of a playing session changes
+type PlayNotify struct {
+	Reason     string
+	Url        string
+	Range      Range
+	HasRange   bool
+	Scale      float64
+	Properties MediaProperties
+	Request    Request
+}
+
+var methods10 = Methods{"OPTIONS", "DESCRIBE", "ANNOUNCE", "SETUP", "PLAY", "PAUSE", "RECORD", "TEARDOWN", "GET_PARAMETER", "SET_PARAMETER", "REDIRECT"}
+
+// rtsp 2.0 removed ANNOUNCE and RECORD and added PLAY_NOTIFY
+var methods20 = Methods{"OPTIONS", "DESCRIBE", "SETUP", "PLAY", "PAUSE", "TEARDOWN", "GET_PARAMETER", "SET_PARAMETER", "REDIRECT", "PLAY_NOTIFY"}
+
+// VersionMethods returns the methods defined by an rtsp version
+func VersionMethods(version string) Methods {
+	if version == RTSP20 {
+		return methods20
+	}
+	return methods10
+}
+
+// SetVersion selects the version sent by the client, RTSP10 by default.
+// With RTSP20 the client falls back to 1.0 if the server does not support 2.0
+func (c *Rtspclient) SetVersion(version string) error {
+	if !validVersion(version) {
+		return errors.New("unsupport rtsp version " + version)
+	}
+	c.mtx.Lock()
+	c.version = version
+	c.mtx.Unlock()
+	return nil
+}
+
+// Version is the version in use, it changes after a fallback to 1.0
+func (c *Rtspclient) Version() string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.version
+}
+
+// EnablePipelining sends every SETUP and the PLAY without waiting for the
+// responses, it needs a rtsp 2.0 server which supports Pipelined-Requests
+func (c *Rtspclient) EnablePipelining() {
+	c.pipelining = true
+}
+
+// MediaProperties is the last Media-Properties sent by a rtsp 2.0 server
+func (c *Rtspclient) MediaProperties() MediaProperties {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.properties
+}
+
+// addVersionHeaders is called holding mtx
+func (c *Rtspclient) addVersionHeaders(req *Request) {
+	if req.Version != RTSP20 {
+		return
+	}
+	switch req.Method {
+	case "OPTIONS":
+		req.Header.Set("Supported", "play.basic, play.scale, play.speed")
+	case "SETUP":
+		req.Header.Set("Supported", "play.basic, play.scale, play.speed")
+		req.Header.Set("Accept-Ranges", "npt, clock, smpte")
+	}
+	//the requests sent before the session exists share the session created by the first SETUP
+	if c.pipelineId != "" && c.session == "" && (req.Method == "SETUP" || req.Method == "PLAY") {
+		req.Header.Set("Pipelined-Requests", c.pipelineId)
+	}
+}
+
+// negotiateVersion falls back to 1.0 if a server which does not know 2.0
+// answers the first requests with 505 or with a 1.0 response
+func (c *Rtspclient) negotiateVersion(res Response) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.version != RTSP20 || c.session != "" {
+		return false
+	}
+	if res.StatusCode == "505" {
+		c.version = RTSP10
+		return true
+	}
+	if res.Version == RTSP10 {
+		c.version = RTSP10
+		return res.StatusCode != "200" && res.StatusCode != "401"
+	}
+	return false
+}
+
+func removeVersionHeaders(req *Request) {
+	req.Header.Del("Supported")
+	req.Header.Del("Accept-Ranges")
+	req.Header.Del("Pipelined-Requests")
+}
+
+// with Pipelined-Requests the server applies every SETUP and the PLAY to the
+// session created by the first SETUP, so nothing waits for a response
+func (c *Rtspclient) setupPipelined(ctx context.Context, tracks []int) error {
+	c.mtx.Lock()
+	c.pipelineId = strconv.Itoa(int(rand.Uint32() % 100000000))
+	c.mtx.Unlock()
+	var calls []*call
+	var methods []string
+	for _, track := range tracks {
+		track := track
+		if track >= len(c.mediaChanel) {
+			continue
+		}
+		req := c.makeSetup(track)
+		cl, err := c.start(req, func(res Response) error {
+			return c.applySetup(track, res)
+		})
+		if err != nil {
+			return err
+		}
+		calls = append(calls, cl)
+		methods = append(methods, req.Method)
+	}
+	cl, err := c.start(c.makePlay(), c.handlePlay)
+	if err != nil {
+		return err
+	}
+	calls = append(calls, cl)
+	methods = append(methods, "PLAY")
+	for i, cl := range calls {
+		if _, err := c.wait(ctx, cl, methods[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Rtspclient) parseMediaHeaders(res Response) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if res.Header.Has("Accept-Ranges") {
+		c.acceptRanges = ParseAcceptRanges(res.Header.Get("Accept-Ranges"))
+	}
+	if res.Header.Has("Media-Properties") {
+		if properties, err := ParseMediaProperties(res.Header.Get("Media-Properties")); err == nil {
+			c.properties = properties
+		}
+	}
+}
+
+// handleServerMessage answers requests sent by the server, PLAY_NOTIFY in
+// rtsp 2.0 or OPTIONS and GET_PARAMETER used by some servers as keepalive
+func (c *Rtspclient) handleServerMessage() (bool, error) {
+	var req Request
+	state := req.Decode(c.recvBuf.Bytes())
+	if state == Failed {
+		return false, errors.New("rtsp request error")
+	} else if state == InCompleted {
+		return true, nil
+	}
+	c.recvBuf.Next(req.TotalLen)
+	c.logDebug("recv request", "method", req.Method, "cseq", req.Header.Get("CSeq"))
+
+	var res Response
+	res.Version = req.Version
+	res.StatusCode = "200"
+	res.Reason = "OK"
+	res.Header.Set("CSeq", req.Header.Get("CSeq"))
+	if req.Header.Has("Session") {
+		res.Header.Set("Session", req.Header.Get("Session"))
+	}
+	switch req.Method {
+	case "PLAY_NOTIFY":
+		if err := c.handlePlayNotify(req); err != nil {
+			res.StatusCode = "400"
+			res.Reason = "Bad Request"
+		}
+	case "OPTIONS", "GET_PARAMETER":
+	default:
+		res.StatusCode = "501"
+		res.Reason = "Not Implemented"
+	}
+	res.Header.Set("Content-Length", "0")
+	return false, c.write([]byte(res.ToString()))
+}
+
+func (c *Rtspclient) handlePlayNotify(req Request) error {
+	notify := PlayNotify{Reason: req.Header.Get("Notify-Reason"), Url: req.Uri, Request: req}
+	if notify.Reason == "" {
+		return errors.New("PLAY_NOTIFY has no Notify-Reason")
+	}
+	var err error
+	if rangestr := req.Header.Get("Range"); rangestr != "" {
+		if notify.Range, err = ParseRange(rangestr); err != nil {
+			return err
+		}
+		notify.HasRange = true
+	}
+	if scale := req.Header.Get("Scale"); scale != "" {
+		if notify.Scale, err = strconv.ParseFloat(scale, 64); err != nil {
+			return errors.New("wrong scale " + scale)
+		}
+	}
+	if properties := req.Header.Get("Media-Properties"); properties != "" {
+		if notify.Properties, err = ParseMediaProperties(properties); err != nil {
+			return err
+		}
+	}
+
+	switch notify.Reason {
+	case NotifyEndOfStream:
+		//the session stays in ready state, PLAY may be sent again
+		c.mtx.Lock()
+		c.playing = false
+		c.mtx.Unlock()
+	case NotifyScaleChange:
+		c.mtx.Lock()
+		c.scale = notify.Scale
+		c.mtx.Unlock()
+	case NotifyMediaPropertiesUpdate:
+		c.mtx.Lock()
+		c.properties = notify.Properties
+		c.mtx.Unlock()
+	}
+	if c.OnNotify != nil {
+		c.OnNotify(notify)
+	}
+	return nil
+}
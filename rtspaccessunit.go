@@ -0,0 +1,164 @@
+package rtsp
+
+import "bytes"
+
+// accessUnit gathers the nal units of a picture, h264 7.4.1.2.3 and h265 7.4.2.4.4.
+// It belongs to the receive goroutine
+type accessUnit struct {
+	codec    Codec
+	ts       uint32
+	data     []byte //annexb, the access unit delimiters are left out
+	out      []byte //data behind the parameter sets of a key frame
+	hasSlice bool
+	isKey    bool
+	hasVps   bool
+	hasSps   bool
+	hasPps   bool
+}
+
+func (au *accessUnit) reset() {
+	au.data = au.data[:0]
+	au.hasSlice = false
+	au.isKey = false
+	au.hasVps = false
+	au.hasSps = false
+	au.hasPps = false
+}
+
+func nalUnitType(codec Codec, nalu []byte) int {
+	if codec == H265 {
+		return int(nalu[0] >> 1 & 0x3F)
+	}
+	return int(nalu[0] & 0x1F)
+}
+
+func isSliceNal(codec Codec, nalType int) bool {
+	if codec == H265 {
+		return nalType <= 31
+	}
+	return nalType >= 1 && nalType <= 5
+}
+
+// startsAccessUnit tells if nalu begins the next picture, given that the
+// current one has a slice
+func startsAccessUnit(codec Codec, nalu []byte) bool {
+	nalType := nalUnitType(codec, nalu)
+	if codec == H265 {
+		switch {
+		case nalType <= 31:
+			//first_slice_segment_in_pic_flag
+			return len(nalu) > 2 && nalu[2]&0x80 != 0
+		case nalType >= 32 && nalType <= 35, nalType == 39, nalType >= 41 && nalType <= 44, nalType >= 48 && nalType <= 55:
+			return true
+		}
+		return false
+	}
+	switch {
+	case nalType >= 1 && nalType <= 5:
+		//first_mb_in_slice is zero, a ue(v) of a single 1 bit
+		return len(nalu) > 1 && nalu[1]&0x80 != 0
+	case nalType >= 6 && nalType <= 9, nalType >= 14 && nalType <= 18:
+		return true
+	}
+	return false
+}
+
+// onVideo gets the nal units of a track in decoding order, a picture is
+// delivered on the rtp marker bit, on the first nal unit of the next picture
+// or when the timestamp changes
+func (c *Rtspclient) onVideo(track int, codec Codec, videoData []byte, timestamp uint32, marker bool) {
+	nalu := trimStartCode(videoData)
+	if len(nalu) == 0 || codec == H265 && len(nalu) < 2 {
+		return
+	}
+	au := c.mediaChanel[track].au
+	if au.hasSlice && (au.ts != timestamp || au.codec != codec || startsAccessUnit(codec, nalu)) {
+		c.flushAccessUnit(track)
+	}
+	au.codec = codec
+	au.ts = timestamp
+	nalType := nalUnitType(codec, nalu)
+	aud := false
+	if codec == H264 {
+		switch nalType {
+		case 5:
+			au.isKey = true
+		case 7:
+			au.hasSps = true
+			if !bytes.Equal(c.sps, videoData) {
+				c.sps = append([]byte(nil), videoData...)
+				c.updateVideoInfo(track, codec, c.sps)
+			}
+		case 8:
+			au.hasPps = true
+			if !bytes.Equal(c.pps, videoData) {
+				c.pps = append([]byte(nil), videoData...)
+			}
+		case 9:
+			aud = true
+		}
+	} else {
+		switch {
+		case nalType >= 16 && nalType <= 21:
+			au.isKey = true
+		case nalType == 32:
+			au.hasVps = true
+			if !bytes.Equal(c.vps, videoData) {
+				c.vps = append([]byte(nil), videoData...)
+			}
+		case nalType == 33:
+			au.hasSps = true
+			if !bytes.Equal(c.sps, videoData) {
+				c.sps = append([]byte(nil), videoData...)
+				c.updateVideoInfo(track, codec, c.sps)
+			}
+		case nalType == 34:
+			au.hasPps = true
+			if !bytes.Equal(c.pps, videoData) {
+				c.pps = append([]byte(nil), videoData...)
+			}
+		case nalType == 35:
+			aud = true
+		}
+	}
+	if !aud {
+		if isSliceNal(codec, nalType) {
+			au.hasSlice = true
+		}
+		au.data = append(au.data, 0x00, 0x00, 0x00, 0x01)
+		au.data = append(au.data, nalu...)
+	}
+	if marker {
+		c.flushAccessUnit(track)
+	}
+}
+
+// flushAccessUnit delivers the picture, a key frame gets the parameter sets
+// it does not carry. Without a slice the nal units wait for the next picture
+func (c *Rtspclient) flushAccessUnit(track int) {
+	au := c.mediaChanel[track].au
+	if au == nil || !au.hasSlice {
+		return
+	}
+	data := au.data
+	if au.isKey && (!au.hasSps || !au.hasPps || au.codec == H265 && !au.hasVps) {
+		au.out = au.out[:0]
+		if au.codec == H265 && !au.hasVps {
+			au.out = append(au.out, c.vps...)
+		}
+		if !au.hasSps {
+			au.out = append(au.out, c.sps...)
+		}
+		if !au.hasPps {
+			au.out = append(au.out, c.pps...)
+		}
+		au.out = append(au.out, au.data...)
+		data = au.out
+	}
+	videoFrame := Frame{Cid: au.codec, Data: data, Ts: au.ts, IsKey: au.isKey}
+	au.reset()
+	c.fillReplayInfo(track, &videoFrame)
+	c.adjustTimestamp(track, &videoFrame)
+	c.countFrame(track, videoFrame)
+	c.deliver(videoFrame)
+}
@@ -0,0 +1,131 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	auSps   = []byte{0x67, 0x42, 0x00, 0x1E, 0xAB, 0x40, 0x50, 0x1E, 0xD3, 0x50, 0x10, 0x10, 0x14, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xCA, 0x10}
+	auPps   = []byte{0x68, 0xCE, 0x3C, 0x80}
+	auAud   = []byte{0x09, 0xF0}
+	auIdr   = []byte{0x65, 0x88, 0x84} //first_mb_in_slice 0
+	auIdr2  = []byte{0x65, 0x40, 0x84} //a second slice of the picture
+	auSlice = []byte{0x41, 0x9A, 0x02}
+	auSei   = []byte{0x06, 0x05, 0x01, 0x80}
+)
+
+// accessUnitClient has a h264 video track and keeps the delivered frames
+func accessUnitClient(frames *[]Frame) *Rtspclient {
+	c := BuildRtspClient("rtsp://127.0.0.1/live")
+	c.mediaChanel = []meidaTransport{{codec: H264, au: new(accessUnit)}}
+	c.OnFrame = func(frame Frame) {
+		frame.Data = append([]byte(nil), frame.Data...)
+		*frames = append(*frames, frame)
+	}
+	return c
+}
+
+func annexbOf(nalus ...[]byte) []byte {
+	var data []byte
+	for _, nalu := range nalus {
+		data = append(data, 0x00, 0x00, 0x00, 0x01)
+		data = append(data, nalu...)
+	}
+	return data
+}
+
+type auNal struct {
+	nalu   []byte
+	ts     uint32
+	marker bool
+}
+
+func TestAccessUnit(t *testing.T) {
+	tests := []struct {
+		name string
+		nals []auNal
+		want []Frame //the frame of an unfinished picture is not delivered
+	}{
+		{"marker", []auNal{{auSps, 0, false}, {auPps, 0, false}, {auIdr, 0, false}, {auIdr2, 0, true}, {auSlice, 3600, true}},
+			[]Frame{
+				{Cid: H264, Ts: 0, IsKey: true, Data: annexbOf(auSps, auPps, auIdr, auIdr2)},
+				{Cid: H264, Ts: 3600, Data: annexbOf(auSlice)},
+			}},
+		{"lost marker", []auNal{{auSps, 0, false}, {auPps, 0, false}, {auIdr, 0, false}, {auSlice, 3600, true}},
+			[]Frame{
+				{Cid: H264, Ts: 0, IsKey: true, Data: annexbOf(auSps, auPps, auIdr)},
+				{Cid: H264, Ts: 3600, Data: annexbOf(auSlice)},
+			}},
+		//one timestamp for every nal unit, as some cameras send them
+		{"aud starts a picture", []auNal{{auAud, 0, false}, {auSps, 0, false}, {auPps, 0, false}, {auIdr, 0, false}, {auAud, 0, false}, {auSlice, 0, false}},
+			[]Frame{{Cid: H264, Ts: 0, IsKey: true, Data: annexbOf(auSps, auPps, auIdr)}}},
+		{"sps starts a picture", []auNal{{auSlice, 0, false}, {auSps, 0, false}, {auPps, 0, false}, {auIdr, 0, true}},
+			[]Frame{
+				{Cid: H264, Ts: 0, Data: annexbOf(auSlice)},
+				{Cid: H264, Ts: 0, IsKey: true, Data: annexbOf(auSps, auPps, auIdr)},
+			}},
+		{"sei starts a picture", []auNal{{auSlice, 0, false}, {auSei, 0, false}, {auSlice, 0, true}},
+			[]Frame{
+				{Cid: H264, Ts: 0, Data: annexbOf(auSlice)},
+				{Cid: H264, Ts: 0, Data: annexbOf(auSei, auSlice)},
+			}},
+		{"first slice starts a picture", []auNal{{auSlice, 0, false}, {auSlice, 0, false}},
+			[]Frame{{Cid: H264, Ts: 0, Data: annexbOf(auSlice)}}},
+		{"idr without parameter sets", []auNal{{auSps, 0, false}, {auPps, 0, false}, {auIdr, 0, true}, {auSlice, 3600, true}, {auIdr, 7200, true}},
+			[]Frame{
+				{Cid: H264, Ts: 0, IsKey: true, Data: annexbOf(auSps, auPps, auIdr)},
+				{Cid: H264, Ts: 3600, Data: annexbOf(auSlice)},
+				{Cid: H264, Ts: 7200, IsKey: true, Data: annexbOf(auSps, auPps, auIdr)},
+			}},
+		{"idr with only a new pps", []auNal{{auSps, 0, false}, {auPps, 0, false}, {auIdr, 0, true}, {auPps, 3600, false}, {auIdr, 3600, true}},
+			[]Frame{
+				{Cid: H264, Ts: 0, IsKey: true, Data: annexbOf(auSps, auPps, auIdr)},
+				{Cid: H264, Ts: 3600, IsKey: true, Data: annexbOf(auSps, auPps, auIdr)},
+			}},
+		{"parameter sets wait for a slice", []auNal{{auSps, 0, true}, {auPps, 0, true}, {auIdr, 0, true}},
+			[]Frame{{Cid: H264, Ts: 0, IsKey: true, Data: annexbOf(auSps, auPps, auIdr)}}},
+	}
+	for _, tt := range tests {
+		var frames []Frame
+		c := accessUnitClient(&frames)
+		for _, nal := range tt.nals {
+			//the depacketizer hands over nal units with a start code
+			c.onVideo(0, H264, annexbOf(nal.nalu), nal.ts, nal.marker)
+		}
+		if len(frames) != len(tt.want) {
+			t.Errorf("%s: got %d frames, want %d", tt.name, len(frames), len(tt.want))
+			continue
+		}
+		for i, frame := range frames {
+			want := tt.want[i]
+			if frame.Cid != want.Cid || frame.Ts != want.Ts || frame.IsKey != want.IsKey || !bytes.Equal(frame.Data, want.Data) {
+				t.Errorf("%s: frame %d is %v ts %d key %v % x, want %v ts %d key %v % x", tt.name, i,
+					frame.Cid, frame.Ts, frame.IsKey, frame.Data, want.Cid, want.Ts, want.IsKey, want.Data)
+			}
+		}
+	}
+}
+
+func TestAccessUnitH265ParameterSets(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0C, 0x01}
+	sps := []byte{0x42, 0x01, 0x01, 0x01}
+	pps := []byte{0x44, 0x01, 0xC0, 0xF2}
+	idr := []byte{0x26, 0x01, 0xAF} //IDR_W_RADL, first_slice_segment_in_pic_flag
+	trail := []byte{0x02, 0x01, 0xD0}
+	var frames []Frame
+	c := accessUnitClient(&frames)
+	c.mediaChanel[0].codec = H265
+	for _, nal := range []auNal{{vps, 0, false}, {sps, 0, false}, {pps, 0, false}, {idr, 0, true}, {trail, 3600, true}, {idr, 7200, true}} {
+		c.onVideo(0, H265, annexbOf(nal.nalu), nal.ts, nal.marker)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames", len(frames))
+	}
+	if want := annexbOf(vps, sps, pps, idr); !frames[2].IsKey || !bytes.Equal(frames[2].Data, want) {
+		t.Errorf("idr is % x, want % x", frames[2].Data, want)
+	}
+	if frames[1].IsKey || !bytes.Equal(frames[1].Data, annexbOf(trail)) {
+		t.Errorf("trailing picture is % x", frames[1].Data)
+	}
+}
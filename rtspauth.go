@@ -0,0 +1,273 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+type Authenticate interface {
+	scheme() string
+	parse(authenticate string) error
+	authorization(method string, uri string) string
+}
+
+// rfc7617, username and password are only base64 encoded
+type BasicAuthenticate struct {
+	username string
+	password string
+}
+
+func (auth *BasicAuthenticate) scheme() string {
+	return "Basic"
+}
+
+func (auth *BasicAuthenticate) parse(authenticate string) error {
+	return nil
+}
+
+func (auth *BasicAuthenticate) authorization(method string, uri string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.username+":"+auth.password))
+}
+
+// rfc7616, also accepts the rfc2069 challenge without qop
+type DigestAuthenticate struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	stale     bool
+	cnonce    string
+	nc        uint32
+	username  string
+	uri       string
+	method    string
+	password  string
+}
+
+func (auth *DigestAuthenticate) scheme() string {
+	return "Digest"
+}
+
+func (auth *DigestAuthenticate) authorization(method string, uri string) string {
+	auth.method = method
+	auth.uri = uri
+	auth.nc++
+	return auth.digestInfo()
+}
+
+func (auth *DigestAuthenticate) digestInfo() string {
+	digest := fmt.Sprintf("Digest username=%s, realm=%s, nonce=%s, uri=%s, response=\"%s\"",
+		quoteString(auth.username), quoteString(auth.realm), quoteString(auth.nonce), quoteString(auth.uri), auth.authenticateInfo())
+	if auth.algorithm != "" {
+		digest += ", algorithm=" + auth.algorithm
+	}
+	if auth.qop != "" {
+		digest += fmt.Sprintf(", qop=%s, nc=%08x, cnonce=\"%s\"", auth.qop, auth.nc, auth.cnonce)
+	}
+	if auth.opaque != "" {
+		digest += ", opaque=" + quoteString(auth.opaque)
+	}
+	return digest
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS")) {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	case "SHA-512-256":
+		return sha512.New512_256
+	}
+	return nil
+}
+
+// stronger algorithms are preferred when several challenges are offered
+func digestStrength(algorithm string) int {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS")) {
+	case "", "MD5":
+		return 1
+	case "SHA-256":
+		return 2
+	case "SHA-512-256":
+		return 3
+	}
+	return 0
+}
+
+func hashHex(newHash func() hash.Hash, data string) string {
+	h := newHash()
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// rfc7616 3.4.1
+// response = H(H(A1):nonce:nc:cnonce:qop:H(A2)), or H(H(A1):nonce:H(A2)) without qop
+// A1 = username:realm:password, -sess: H(username:realm:password):nonce:cnonce
+// A2 = method:uri
+func (auth *DigestAuthenticate) authenticateInfo() string {
+	newHash := digestHash(auth.algorithm)
+	if newHash == nil {
+		return ""
+	}
+	ha1 := hashHex(newHash, auth.username+":"+auth.realm+":"+auth.password)
+	if strings.HasSuffix(strings.ToUpper(auth.algorithm), "-SESS") {
+		ha1 = hashHex(newHash, ha1+":"+auth.nonce+":"+auth.cnonce)
+	}
+	ha2 := hashHex(newHash, auth.method+":"+auth.uri)
+	if auth.qop == "" {
+		return hashHex(newHash, ha1+":"+auth.nonce+":"+ha2)
+	}
+	return hashHex(newHash, fmt.Sprintf("%s:%s:%08x:%s:%s:%s", ha1, auth.nonce, auth.nc, auth.cnonce, auth.qop, ha2))
+}
+
+func (auth *DigestAuthenticate) parse(authenticate string) error {
+	params, err := parseAuthParams(authenticate)
+	if err != nil {
+		return err
+	}
+	nonce, ok := params["nonce"]
+	if !ok {
+		return errors.New("digest challenge has no nonce")
+	}
+	if digestHash(params["algorithm"]) == nil {
+		return errors.New("unsupport digest algorithm " + params["algorithm"])
+	}
+	auth.qop = ""
+	if qops, ok := params["qop"]; ok {
+		for _, qop := range strings.Split(qops, ",") {
+			if strings.TrimSpace(qop) == "auth" {
+				auth.qop = "auth"
+			}
+		}
+		//auth-int needs the hash of every request body
+		if auth.qop == "" {
+			return errors.New("unsupport digest qop " + qops)
+		}
+	}
+	if nonce != auth.nonce {
+		auth.nc = 0
+		auth.cnonce = newCnonce()
+	}
+	auth.realm = params["realm"]
+	auth.nonce = nonce
+	auth.opaque = params["opaque"]
+	auth.algorithm = params["algorithm"]
+	auth.stale = strings.EqualFold(params["stale"], "true")
+	return nil
+}
+
+func newCnonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func quoteString(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return "\"" + s + "\""
+}
+
+// auth-param = token BWS "=" BWS ( token / quoted-string ), separated by commas
+func parseAuthParams(params string) (map[string]string, error) {
+	result := make(map[string]string)
+	i := 0
+	for i < len(params) {
+		for i < len(params) && (params[i] == ' ' || params[i] == '\t' || params[i] == ',') {
+			i++
+		}
+		if i >= len(params) {
+			break
+		}
+		eq := strings.IndexByte(params[i:], '=')
+		if eq <= 0 {
+			return nil, errors.New("wrong auth param " + params[i:])
+		}
+		name := strings.ToLower(strings.TrimSpace(params[i : i+eq]))
+		i += eq + 1
+		for i < len(params) && (params[i] == ' ' || params[i] == '\t') {
+			i++
+		}
+		var value strings.Builder
+		if i < len(params) && params[i] == '"' {
+			i++
+			closed := false
+			for i < len(params) {
+				if params[i] == '\\' && i+1 < len(params) {
+					value.WriteByte(params[i+1])
+					i += 2
+					continue
+				}
+				if params[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				value.WriteByte(params[i])
+				i++
+			}
+			if !closed {
+				return nil, errors.New("unterminated quoted string in auth param " + name)
+			}
+		} else {
+			end := strings.IndexByte(params[i:], ',')
+			if end == -1 {
+				end = len(params) - i
+			}
+			value.WriteString(strings.TrimSpace(params[i : i+end]))
+			i += end
+		}
+		result[name] = value.String()
+	}
+	return result, nil
+}
+
+func authStrength(auth Authenticate) int {
+	if digest, ok := auth.(*DigestAuthenticate); ok {
+		return 1 + digestStrength(digest.algorithm)
+	}
+	return 0
+}
+
+// newAuthenticate picks the strongest challenge it supports
+func newAuthenticate(challenges []string, username string, password string) (Authenticate, error) {
+	var best Authenticate
+	for _, challenge := range challenges {
+		challenge = strings.TrimSpace(challenge)
+		var auth Authenticate
+		var scheme string
+		if idx := strings.IndexAny(challenge, " \t"); idx > 0 {
+			scheme = challenge[:idx]
+		} else {
+			scheme = challenge
+		}
+		switch strings.ToLower(scheme) {
+		case "digest":
+			auth = &DigestAuthenticate{username: username, password: password}
+		case "basic":
+			auth = &BasicAuthenticate{username: username, password: password}
+		default:
+			continue
+		}
+		if err := auth.parse(challenge[len(scheme):]); err != nil {
+			continue
+		}
+		if best == nil || authStrength(auth) > authStrength(best) {
+			best = auth
+		}
+	}
+	if best == nil {
+		return nil, errors.New("Unsupport auth")
+	}
+	return best, nil
+}
@@ -0,0 +1,186 @@
+package rtsp
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDigestResponseVectors(t *testing.T) {
+	//rfc7616 3.9.1
+	rfc7616 := DigestAuthenticate{
+		realm:    "http-auth@example.org",
+		nonce:    "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+		qop:      "auth",
+		cnonce:   "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+		nc:       1,
+		username: "Mufasa",
+		password: "Circle of Life",
+		uri:      "/dir/index.html",
+		method:   "GET",
+	}
+	//rfc2617 3.5
+	rfc2617 := DigestAuthenticate{
+		realm:    "testrealm@host.com",
+		nonce:    "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		qop:      "auth",
+		cnonce:   "0a4f113b",
+		nc:       1,
+		username: "Mufasa",
+		password: "Circle Of Life",
+		uri:      "/dir/index.html",
+		method:   "GET",
+	}
+	ha1 := md5Hex("Mufasa:testrealm@host.com:Circle Of Life")
+	ha2 := md5Hex("GET:/dir/index.html")
+	sessHa1 := md5Hex(ha1 + ":dcd98b7102dd2f0e8b11d0f600bfb0c093:0a4f113b")
+
+	tests := []struct {
+		name      string
+		auth      DigestAuthenticate
+		algorithm string
+		qop       string
+		want      string
+	}{
+		{"rfc7616 MD5", rfc7616, "MD5", "auth", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"rfc7616 SHA-256", rfc7616, "SHA-256", "auth", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+		{"rfc2617 auth", rfc2617, "", "auth", "6629fae49393a05397450978507c4ef1"},
+		{"rfc2617 MD5-sess", rfc2617, "MD5-sess", "auth",
+			md5Hex(sessHa1 + ":dcd98b7102dd2f0e8b11d0f600bfb0c093:00000001:0a4f113b:auth:" + ha2)},
+		{"rfc2069 without qop", rfc2617, "", "", md5Hex(ha1 + ":dcd98b7102dd2f0e8b11d0f600bfb0c093:" + ha2)},
+		{"unknown algorithm", rfc2617, "SHA-1", "auth", ""},
+	}
+	for _, tt := range tests {
+		auth := tt.auth
+		auth.algorithm = tt.algorithm
+		auth.qop = tt.qop
+		if got := auth.authenticateInfo(); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	auth := &DigestAuthenticate{username: "Mufasa", password: "Circle of Life"}
+	challenge := `realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+	if err := auth.parse(challenge); err != nil {
+		t.Fatal(err)
+	}
+	first, err := parseAuthParams(strings.TrimPrefix(auth.authorization("DESCRIBE", "rtsp://cam/live"), "Digest "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := parseAuthParams(strings.TrimPrefix(auth.authorization("SETUP", "rtsp://cam/live/trackID=1"), "Digest "))
+	want := map[string]string{
+		"username":  "Mufasa",
+		"realm":     "http-auth@example.org",
+		"uri":       "rtsp://cam/live",
+		"algorithm": "SHA-256",
+		"qop":       "auth",
+		"nc":        "00000001",
+		"opaque":    "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+	}
+	for key, value := range want {
+		if first[key] != value {
+			t.Errorf("%s: got %q, want %q", key, first[key], value)
+		}
+	}
+	if second["nc"] != "00000002" || second["cnonce"] != first["cnonce"] || first["cnonce"] == "" {
+		t.Errorf("second request nc %s cnonce %q, first cnonce %q", second["nc"], second["cnonce"], first["cnonce"])
+	}
+
+	//a new nonce starts counting again with a new cnonce
+	if err = auth.parse(strings.Replace(challenge, "7ypf", "8ypf", 1)); err != nil {
+		t.Fatal(err)
+	}
+	third, _ := parseAuthParams(strings.TrimPrefix(auth.authorization("PLAY", "rtsp://cam/live"), "Digest "))
+	if third["nc"] != "00000001" || third["cnonce"] == first["cnonce"] {
+		t.Errorf("new nonce nc %s cnonce %q", third["nc"], third["cnonce"])
+	}
+
+	if err = auth.parse(`realm="x", nonce="n", qop="auth-int"`); err == nil {
+		t.Error("auth-int accepted")
+	}
+	if err = auth.parse(`realm="x", qop="auth"`); err == nil {
+		t.Error("challenge without nonce accepted")
+	}
+}
+
+func TestNewAuthenticatePrefersStrongest(t *testing.T) {
+	auth, err := newAuthenticate([]string{
+		`Basic realm="x"`,
+		`Digest realm="x", nonce="n", algorithm=MD5`,
+		`Digest realm="x", nonce="n", algorithm=SHA-256`,
+		`Digest realm="x", nonce="n", algorithm=unknown`,
+	}, "u", "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest, ok := auth.(*DigestAuthenticate); !ok || digest.algorithm != "SHA-256" {
+		t.Errorf("picked %#v", auth)
+	}
+}
+
+// staleServer answers every DESCRIBE with credentials by a stale nonce, until
+// it has sent stale 401s
+func staleServer(t *testing.T, stale int) *testServer {
+	var mtx sync.Mutex
+	sent := 0
+	return startTestServer(t, &testServer{timeout: 60, reply: func(method string, header map[string]string) string {
+		if method != "DESCRIBE" {
+			return ""
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		challenge := fmt.Sprintf(`WWW-Authenticate: Digest realm="cam", nonce="n%d", qop="auth"`, sent)
+		if header["authorization"] == "" {
+			return "RTSP/1.0 401 Unauthorized\r\nCSeq: " + header["cseq"] + "\r\n" + challenge + "\r\n\r\n"
+		}
+		if sent < stale {
+			sent++
+			return "RTSP/1.0 401 Unauthorized\r\nCSeq: " + header["cseq"] + "\r\n" + challenge + ", stale=true\r\n\r\n"
+		}
+		return ""
+	}})
+}
+
+func TestClientStaleNonce(t *testing.T) {
+	tests := []struct {
+		stale     int
+		err       error
+		describes int
+	}{
+		//the request without credentials, then one with each nonce
+		{0, nil, 2},
+		{1, nil, 3},
+		{5, ErrUnauthorized, 3},
+	}
+	for _, tt := range tests {
+		s := staleServer(t, tt.stale)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		c, err := Dial(ctx, strings.Replace(s.url(), "rtsp://", "rtsp://u:p@", 1), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Describe(ctx)
+		if !errors.Is(err, tt.err) || tt.err == nil && err != nil {
+			t.Errorf("%d stale nonces: got %v, want %v", tt.stale, err, tt.err)
+		}
+		if n := s.count("DESCRIBE"); n != tt.describes {
+			t.Errorf("%d stale nonces: %d DESCRIBE, want %d", tt.stale, n, tt.describes)
+		}
+		c.Close()
+		cancel()
+	}
+}
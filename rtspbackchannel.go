@@ -0,0 +1,128 @@
+package rtsp
+
+import (
+	"errors"
+	"sync"
+)
+
+// ONVIF Streaming Specification 5.3, audio backchannel
+const onvifBackChannelTag = "www.onvif.org/ver20/backchannel"
+
+type backChannel struct {
+	mtx     sync.Mutex
+	track   int
+	codec   Codec
+	packer  *rtpPacker
+	channel int
+	ready   bool
+}
+
+// only the first sendonly audio track offered by the server is used
+func (b *backChannel) offer(media MediaDescription, track int) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	//offered again by the DESCRIBE of a reconnect
+	if b.packer != nil {
+		return b.track == track
+	}
+	//the first format the client can send
+	pt, codec := -1, UNSupport
+	var aac AACParams
+	for _, format := range media.PayloadTypes() {
+		rtpmap, ok := media.RtpMapOf(format)
+		if !ok {
+			continue
+		}
+		cid := codecByName(rtpmap.EncodingName)
+		if cid == AAC {
+			params, err := backChannelAAC(media, format)
+			if err != nil {
+				continue
+			}
+			aac = params
+		}
+		if cid == G711A || cid == G711U || cid == AAC {
+			pt, codec = format, cid
+			break
+		}
+	}
+	if pt < 0 {
+		return false
+	}
+	b.track = track
+	b.codec = codec
+	b.packer = newRtpPacker(codec, uint8(pt))
+	b.packer.aac = aac
+	return true
+}
+
+// backChannelAAC is the AU header the server asks for, AAC-hbr if it has no fmtp
+func backChannelAAC(media MediaDescription, pt int) (AACParams, error) {
+	fmtp, ok := media.FmtpOf(pt)
+	if !ok {
+		return defaultAACParams, nil
+	}
+	aac, err := ParseAACParams(fmtp.Params)
+	if err != nil {
+		return AACParams{}, err
+	}
+	return aac, aacPackable(aac)
+}
+
+func (b *backChannel) start(medias []meidaTransport) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.packer == nil || b.track >= len(medias) {
+		return
+	}
+	b.channel = medias[b.track].RtpChannel
+	b.ready = true
+}
+
+func (b *backChannel) write(frame Frame, send func([]byte) error) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if !b.ready {
+		return errors.New("backchannel is not ready")
+	}
+	if frame.Cid != b.codec {
+		return errors.New("frame codec mismatch with backchannel")
+	}
+	b.packer.onRtp = func(packet []byte) error {
+		interleaved := make([]byte, 4, 4+len(packet))
+		interleaved[0] = '$'
+		interleaved[1] = byte(b.channel)
+		interleaved[2] = byte(len(packet) >> 8)
+		interleaved[3] = byte(len(packet))
+		return send(append(interleaved, packet...))
+	}
+	return b.packer.pack(frame.Data, frame.Ts)
+}
+
+// EnableBackChannel must be called before Start, the server is asked for
+// the onvif backchannel in DESCRIBE
+func (c *Rtspclient) EnableBackChannel() {
+	c.backchannel = &backChannel{}
+}
+
+// BackChannelCodec returns UNSupport if the server offered no usable backchannel
+func (c *Rtspclient) BackChannelCodec() Codec {
+	if c.backchannel == nil {
+		return UNSupport
+	}
+	c.backchannel.mtx.Lock()
+	defer c.backchannel.mtx.Unlock()
+	if c.backchannel.packer == nil {
+		return UNSupport
+	}
+	return c.backchannel.codec
+}
+
+// WriteBackChannel sends one G711 or AAC frame to the camera, frame.Ts is
+// in units of the track clock rate. It can be used once PLAY succeeded
+func (c *Rtspclient) WriteBackChannel(frame Frame) error {
+	if c.backchannel == nil {
+		return errors.New("backchannel is not enabled")
+	}
+	return c.backchannel.write(frame, c.write)
+}
@@ -0,0 +1,233 @@
+package rtsp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func packAll(t *testing.T, p *rtpPacker, frame []byte, ts uint32) ([]rtp, error) {
+	t.Helper()
+	var packets []rtp
+	p.onRtp = func(packet []byte) error {
+		var r rtp
+		if err := r.decode(packet); err != nil {
+			t.Fatal(err)
+		}
+		//payload points into the packet of the packer
+		r.payload = append([]byte(nil), r.payload...)
+		packets = append(packets, r)
+		return nil
+	}
+	err := p.pack(frame, ts)
+	return packets, err
+}
+
+func TestRtpPackerG711(t *testing.T) {
+	p := newRtpPacker(G711A, 8)
+	frame := make([]byte, 3000)
+	for i := range frame {
+		frame[i] = byte(i)
+	}
+	packets, err := packAll(t, p, frame, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := []int{1400, 1400, 200}
+	if len(packets) != len(sizes) {
+		t.Fatalf("got %d packets", len(packets))
+	}
+	var data []byte
+	for i, packet := range packets {
+		if packet.head.pt != 8 || packet.head.mark || len(packet.payload) != sizes[i] {
+			t.Errorf("packet %d: pt %d marker %v size %d", i, packet.head.pt, packet.head.mark, len(packet.payload))
+		}
+		//one sample per byte
+		if want := uint32(1000 + 1400*i); packet.head.timestamp != want {
+			t.Errorf("packet %d: ts %d, want %d", i, packet.head.timestamp, want)
+		}
+		if packet.head.seqnum != packets[0].head.seqnum+uint16(i) || packet.head.ssrc != packets[0].head.ssrc {
+			t.Errorf("packet %d: seq %d ssrc %x", i, packet.head.seqnum, packet.head.ssrc)
+		}
+		data = append(data, packet.payload...)
+	}
+	if !bytes.Equal(data, frame) {
+		t.Error("payloads differ from the frame")
+	}
+}
+
+func TestRtpPackerAAC(t *testing.T) {
+	adts := []byte{0xFF, 0xF1, 0x50, 0x80, 0x02, 0x1F, 0xFC}
+	tests := []struct {
+		name   string
+		fmtp   string //the default AAC-hbr if empty
+		frame  []byte
+		header []byte
+		size   int //of the AU after the header
+		err    bool
+	}{
+		{"default", "", make([]byte, 100), []byte{0x00, 0x10, 0x03, 0x20}, 100, false},
+		{"hbr", "streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1408",
+			make([]byte, 100), []byte{0x00, 0x10, 0x03, 0x20}, 100, false},
+		{"adts", "", append(adts, make([]byte, 10)...), []byte{0x00, 0x10, 0x00, 0x50}, 10, false},
+		{"lbr", "streamtype=5;mode=AAC-lbr;sizelength=6;indexlength=2;indexdeltalength=2;config=1408",
+			make([]byte, 50), []byte{0x00, 0x08, 0xC8}, 50, false},
+		{"cts and dts flags", "mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;ctsdeltalength=3;dtsdeltalength=3",
+			make([]byte, 100), []byte{0x00, 0x12, 0x03, 0x20, 0x00}, 100, false},
+		{"lbr too large", "mode=AAC-lbr;sizelength=6;indexlength=2;indexdeltalength=2", make([]byte, 64), nil, 0, true},
+		{"hbr too large", "", make([]byte, 8192), nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRtpPacker(AAC, 97)
+			if tt.fmtp != "" {
+				aac, err := ParseAACParams(tt.fmtp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = aacPackable(aac); err != nil {
+					t.Fatal(err)
+				}
+				p.aac = aac
+			}
+			packets, err := packAll(t, p, tt.frame, 1024)
+			if tt.err {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(packets) != 1 || !packets[0].head.mark || packets[0].head.timestamp != 1024 {
+				t.Fatalf("got %d packets %+v", len(packets), packets)
+			}
+			if payload := packets[0].payload; !bytes.HasPrefix(payload, tt.header) || len(payload) != len(tt.header)+tt.size {
+				t.Errorf("payload % x, want header % x and %d bytes", payload[:len(tt.header)], tt.header, tt.size)
+			}
+		})
+	}
+}
+
+func TestRtpPackerAACFragments(t *testing.T) {
+	p := newRtpPacker(AAC, 97)
+	frame := make([]byte, 3000)
+	packets, err := packAll(t, p, frame, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 3 {
+		t.Fatalf("got %d packets", len(packets))
+	}
+	size := 0
+	for i, packet := range packets {
+		//every fragment carries the size of the whole AU, 3000 = 0x5D<<5 | 0x18
+		if !bytes.HasPrefix(packet.payload, []byte{0x00, 0x10, 0x5D, 0xC0}) {
+			t.Errorf("fragment %d header % x", i, packet.payload[:4])
+		}
+		if packet.head.mark != (i == 2) {
+			t.Errorf("fragment %d marker %v", i, packet.head.mark)
+		}
+		size += len(packet.payload) - 4
+	}
+	if size != len(frame) {
+		t.Errorf("fragments carry %d bytes", size)
+	}
+}
+
+func backChannelMedia(formats string, attrs ...string) MediaDescription {
+	sdp := "v=0\r\no=- 0 0 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\nm=audio 0 RTP/AVP " + formats + "\r\na=sendonly\r\n"
+	for _, attr := range attrs {
+		sdp += "a=" + attr + "\r\n"
+	}
+	parsed, _ := Parse(sdp)
+	return parsed.Medias[0]
+}
+
+func TestBackChannelOffer(t *testing.T) {
+	tests := []struct {
+		name  string
+		media MediaDescription
+		ok    bool
+		codec Codec
+		mode  string
+	}{
+		{"g711u", backChannelMedia("0"), true, G711U, ""},
+		{"aac without fmtp", backChannelMedia("97", "rtpmap:97 MPEG4-GENERIC/16000"), true, AAC, "AAC-hbr"},
+		{"aac lbr", backChannelMedia("97", "rtpmap:97 MPEG4-GENERIC/16000",
+			"fmtp:97 streamtype=5;mode=AAC-lbr;sizelength=6;indexlength=2;indexdeltalength=2;config=1408"), true, AAC, "AAC-lbr"},
+		{"unsupported aac before g711", backChannelMedia("97 8", "rtpmap:97 MPEG4-GENERIC/16000",
+			"fmtp:97 streamtype=5;mode=CELP-cbr;constantsize=24;config=1408"), true, G711A, ""},
+		{"aac with constant size", backChannelMedia("97", "rtpmap:97 MPEG4-GENERIC/16000",
+			"fmtp:97 streamtype=5;mode=AAC-hbr;constantsize=512;config=1408"), false, UNSupport, ""},
+		{"opus", backChannelMedia("111", "rtpmap:111 opus/48000/2"), false, UNSupport, ""},
+	}
+	for _, tt := range tests {
+		var b backChannel
+		ok := b.offer(tt.media, 1)
+		if ok != tt.ok || ok && (b.codec != tt.codec || b.packer.aac.Mode != tt.mode) {
+			t.Errorf("%s: got %v %v %q", tt.name, ok, b.codec, b.packer.aac.Mode)
+		}
+	}
+}
+
+const backChannelSdp = testServerSdp +
+	"m=audio 0 RTP/AVP 8\r\n" +
+	"a=rtpmap:8 PCMA/8000\r\n" +
+	"a=sendonly\r\n" +
+	"a=control:track2\r\n"
+
+func TestWriteBackChannel(t *testing.T) {
+	s := startTestServer(t, &testServer{timeout: 60, sdp: backChannelSdp})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, s.url(), &Options{BackChannel: true, OnFrame: func(frame Frame) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	desc, err := c.Describe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(desc.Tracks) != 2 || !desc.Tracks[1].BackChannel || c.BackChannelCodec() != G711A {
+		t.Fatalf("tracks %+v, backchannel %v", desc.Tracks, c.BackChannelCodec())
+	}
+	for track := range desc.Tracks {
+		if err = c.Setup(ctx, track); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frame := Frame{Cid: G711A, Data: bytes.Repeat([]byte{0xD5}, 320), Ts: 160}
+	if err = c.WriteBackChannel(frame); err == nil {
+		t.Error("written before PLAY")
+	}
+	if err = c.Play(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.WriteBackChannel(Frame{Cid: AAC, Data: []byte{1}}); err == nil {
+		t.Error("aac written to a g711 backchannel")
+	}
+	if err = c.WriteBackChannel(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	//the second SETUP got interleaved=2-3
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.receivedOn(2)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	packets := s.receivedOn(2)
+	if len(packets) != 1 {
+		t.Fatalf("server got %d packets", len(packets))
+	}
+	var r rtp
+	if err = r.decode(packets[0]); err != nil {
+		t.Fatal(err)
+	}
+	if r.head.pt != 8 || r.head.timestamp != 160 || !bytes.Equal(r.payload, frame.Data) {
+		t.Errorf("got pt %d ts %d %d bytes", r.head.pt, r.head.timestamp, len(r.payload))
+	}
+}
@@ -2,15 +2,18 @@ package rtsp
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
-	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
+	"math/rand"
 	"net"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,30 +28,110 @@ const (
 	G711U
 )
 
+func (c Codec) isVideo() bool {
+	return c == H264 || c == H265
+}
+
+func (c Codec) String() string {
+	switch c {
+	case H264:
+		return "H264"
+	case H265:
+		return "H265"
+	case AAC:
+		return "AAC"
+	case G711A:
+		return "PCMA"
+	case G711U:
+		return "PCMU"
+	}
+	return "unsupport"
+}
+
+// rfc2326 3.7 absolute time, utc
+const onvifClockFormat = "20060102T150405.000Z"
+
 type Frame struct {
 	Cid   Codec
 	Data  []byte
 	Ts    uint32
 	IsKey bool
+	//absolute time carried by the onvif replay header extension, zero if absent
+	NtpTime time.Time
+	//first frame after a seek, or marked by the onvif replay D bit
+	Discontinuity bool
+	buf           *frameBuffer //owner of Data for frames of Frames
 }
 
-type HandleRtspMethod interface {
-	handleOption(res Response) error
-	handleDescribe(res Response) error
-	handleSetup(res Response) error
-	handlePlay(res Response) error
-	handleTearDown(res Response) error
-	handleReponse(res Response) error
-	handleUnauthorized(res Response) error
+// OnvifReplay configures playback of recordings through ONVIF Replay
+// (ONVIF Streaming Specification, section 6)
+type OnvifReplay struct {
+	Start time.Time
+	End   time.Time //zero means open-ended
+	//false sends "Rate-Control: no", the server streams as fast as it can
+	RateControl bool
+	Immediate   bool
+	//"intra", "intra/<interval ms>" or "predicted", empty means all frames
+	Frames string
 }
 
 type meidaTransport struct {
 	uri         string
+	media       string
+	codec       Codec
+	pt          int
 	RtpChannel  int
 	RtcpChannel int
-	rtpdecoder  payload
+	formats     []rtpFormat //the payload types of the media the client can decode
+	h264        *H264Params //the fmtp of the first format, by codec
+	h265        *H265Params
+	aac         *AACParams
+	video       VideoInfo //of the last sps
+	hasVideo    bool
+	au          *accessUnit //of video tracks
+	clockRate   int
+	backchannel bool
+	replayExt   *OnvifReplayExtension
+	replayTs    uint32
+	discontinue bool
+	waitSeq     bool //drop packets sent before a seek
+	startSeq    uint16
+	ssrc        uint32
+	highestSeq  uint32 //extended with cycles
+	baseSeq     uint32
+	received    bool
+	//rtcp reception state of the session, rfc3550 A.3 and A.8
+	packetsReceived uint32
+	expectedPrior   uint32
+	receivedPrior   uint32
+	arrivalBase     time.Time
+	transit         uint32
+	hasTransit      bool
+	lsr             uint32 //of the last sender report
+	lsrTime         time.Time
+	stats           trackCounters
+}
+
+type rtpFormat struct {
+	pt      int
+	codec   Codec
+	decoder payload //nil for the codecs without a depacketizer
 }
 
+func (m *meidaTransport) format(pt int) *rtpFormat {
+	for i := range m.formats {
+		if m.formats[i].pt == pt {
+			return &m.formats[i]
+		}
+	}
+	return nil
+}
+
+// Rtspclient is used by the caller, the receive goroutine of the connection,
+// the keepalive goroutine and the reconnect goroutine. mtx guards the session
+// state, writeMtx the writes to conn and statsMtx the tracks in mediaChanel.
+// conn is replaced holding mtx and writeMtx, so either of them is enough to read it.
+// recvBuf, the decoders and the timelines belong to the receive goroutine
 type Rtspclient struct {
 	url           string
 	username      string
@@ -57,8 +140,19 @@ type Rtspclient struct {
 	conn          net.Conn
 	recvBuf       *bytes.Buffer
 	mediaChanel   []meidaTransport
-	stopFlag      bool
-	setupStep     int
+	stopFlag      bool //of the current connection
+	recvDone      chan struct{}
+	stopped       bool //by Stop or after the last reconnect attempt
+	stopOnce      sync.Once
+	finished      bool
+	done          chan struct{}
+	reconnect     *ReconnectPolicy
+	supervised    bool
+	lostError     error
+	state         State
+	OnStateChange func(state State, err error)
+	selected      []int //tracks setup, again after a reconnect
+	timelines     []timeline
 	cseq          int
 	session       string
 	sdp           Rtspsdp
@@ -69,53 +163,55 @@ type Rtspclient struct {
 	sps           []byte
 	pps           []byte
 	vps           []byte
-	handleReponse func(res Response) error
-	OnFrame       func(frame Frame)
-	auth          DigestAuthenticate
-	needAuth      bool
+	logger        Logger
+	fields        atomic.Value      //logFields
+	OnFrame       func(frame Frame) //runs on the receive goroutine, Data is only valid during the call
+	frames        *frameQueue
+	reconnects    int
+	startTime     time.Time //of Start or Dial, kept by statsMtx
+	firstFrame    time.Time
+	queueDepth    int
+	dropPolicy    DropPolicy
+	auth          Authenticate
+	basicAuth     bool //send basic credentials without waiting for a 401
 	keepAlive     bool
-	aliveTicker   *time.Ticker
+	aliveStrategy KeepAliveStrategy
+	getParameter  bool //server lists GET_PARAMETER in Public
+	quit          chan struct{}
+	pending       map[int]*pendingRequest
+	rtcpSsrc      uint32
+	statsMtx      sync.Mutex
+	replay        *OnvifReplay
+	backchannel   *backChannel
+	writeMtx      sync.Mutex
+	mtx           sync.Mutex
+	playing       bool
+	paused        bool
+	scale         float64
+	speed         float64
+	playRange     Range
+	timeout       time.Duration //of a request
+	dialTimeout   time.Duration
+	tlsConfig     *tls.Config
+	version       string
+	pipelining    bool
+	pipelineId    string
+	acceptRanges  AcceptRanges
+	properties    MediaProperties
+	OnNotify      func(notify PlayNotify)
 }
 
 func (c *Rtspclient) handleOption(res Response) error {
-
-	if res.StatusCode == "401" {
-		return c.handleUnauthorized("OPTIONS", res)
-	}
-	if c.keepAlive {
-		return nil
-	}
-	_, ok := res.HeaderFileds["Public"]
-	if !ok {
-		fmt.Println("WARNING,has no Public Filed")
-	}
-
-	if c.setupStep == 0 { //start to create rtsp session
-		describeReq := MakeDescribe(c.url)
-		describeReq.HeaderFileds["CSeq"] = strconv.Itoa(c.cseq)
-		c.cseq++
-		c.handleReponse = c.handleDescribe
-		c.auth.uri = c.url
-		c.auth.method = "DESCRIBE"
-		if c.needAuth {
-			authinfo := c.auth.digestInfo()
-			describeReq.HeaderFileds["Authorization"] = authinfo
-		}
-		return c.sendRtspCommad([]byte(describeReq.ToString()))
-	} else { //for keepalive
-		//do something ,but now,i don't konw
-		fmt.Println("keepalive options response")
-		return nil
+	if !res.Header.Has("Public") {
+		c.logWarn("OPTIONS response has no Public")
 	}
+	c.getParameter = ParseMethods(res.Header.Get("Public")).Has("GET_PARAMETER")
+	return nil
 }
 
 func (c *Rtspclient) handleDescribe(res Response) error {
-
-	if res.StatusCode == "401" {
-		return c.handleUnauthorized("DESCRIBE", res)
-	}
-
 	var err error
+	c.logDebug("sdp", "sdp", string(res.Body))
 	c.sdp, err = Parse(string(res.Body))
 	if err != nil {
 		return err
@@ -124,413 +220,558 @@ func (c *Rtspclient) handleDescribe(res Response) error {
 		return errors.New("has no media describe")
 	}
 
-	// 1.     The RTSP Content-Base field
-	// 2.     The RTSP Content-Location field
-	// 3.     The RTSP request URL
-	baseurl, exist := res.HeaderFileds["Content-Base"]
-	if !exist {
-		locationurl, exist := res.HeaderFileds["Content-Location"]
-		if !exist {
-			baseurl = c.url
-		} else {
-			baseurl = locationurl
-		}
-	}
+	var medias []meidaTransport
+	baseurl := res.ContentBase(c.url)
 	if !strings.HasSuffix(baseurl, "/") {
 		baseurl += "/"
 	}
 
 	for i := 0; i < len(c.sdp.Medias); i++ {
+		media := &c.sdp.Medias[i]
+		if media.Media != "video" && media.Media != "audio" {
+			continue
+		}
 		var mediaTrans meidaTransport
 		mediaTrans.RtcpChannel = -1
 		mediaTrans.RtpChannel = -1
-		mediaTrans.rtpdecoder, _ = createRtpPayloadByName(c.sdp.Medias[i].rtpmap.encodeName)
-		if c.sdp.Medias[i].describe.media == "video" {
-			if c.sdp.Medias[i].rtpmap.encodeName == "H264" {
-				c.vcid = H264
-
-				params := strings.Split(c.sdp.Medias[i].fmtp.paramters, ";")
-				for i := 0; i < len(params); i++ {
-					if strings.Contains(params[i], "sprop-parameter-sets") {
-						spropParameterSets := strings.TrimSpace(params[i])
-						spspps := strings.Split(strings.TrimPrefix(spropParameterSets, "sprop-parameter-sets="), ",")
-						spsbase64 := spspps[0]
-						ppsbase64 := spspps[1]
-						fmt.Println("get sps from sdp")
-						fmt.Println(params[i])
-						fmt.Println(spspps)
-						c.sps, _ = base64.StdEncoding.DecodeString(spsbase64)
-						c.sps = append([]byte{0x00, 0x00, 0x00, 0x01}, c.sps...)
-						c.pps, _ = base64.StdEncoding.DecodeString(ppsbase64)
-						c.pps = append([]byte{0x00, 0x00, 0x00, 0x01}, c.pps...)
-					}
+		mediaTrans.media = media.Media
+		mediaTrans.pt = media.RtpMap.PayloadType
+		mediaTrans.clockRate = media.RtpMap.ClockRate
+		track := len(medias)
+		if media.Media == "audio" && c.backchannel != nil && media.Direction == DirectionSendOnly {
+			if !c.backchannel.offer(*media, track) {
+				continue
+			}
+			mediaTrans.backchannel = true
+			mediaTrans.codec = c.backchannel.codec
+		} else {
+			for _, pt := range media.PayloadTypes() {
+				rtpmap, ok := media.RtpMapOf(pt)
+				if !ok {
+					continue
+				}
+				codec := codecByName(rtpmap.EncodingName)
+				if codec == UNSupport || codec.isVideo() != (media.Media == "video") {
+					continue
 				}
-			} else if c.sdp.Medias[i].rtpmap.encodeName == "H265" {
-				params := strings.Split(c.sdp.Medias[i].fmtp.paramters, ";")
-				for i := 0; i < len(params); i++ {
-					if strings.Contains(params[i], "sprop-vps") {
-						vpsbase64 := strings.TrimPrefix(params[i], "sprop-vps=")
-						c.vps, _ = base64.StdEncoding.DecodeString(vpsbase64)
-						c.vps = append([]byte{0x00, 0x00, 0x00, 0x01}, c.vps...)
-					} else if strings.Contains(params[i], "sprop-sps") {
-						spsbase64 := strings.TrimSpace(params[i])
-						spsbase64 = strings.TrimPrefix(spsbase64, "sprop-sps=")
-						c.sps, _ = base64.StdEncoding.DecodeString(spsbase64)
-						c.sps = append([]byte{0x00, 0x00, 0x00, 0x01}, c.sps...)
-					} else if strings.Contains(params[i], "sprop-pps") {
-						ppsbase64 := strings.TrimSpace(params[i])
-						ppsbase64 = strings.TrimPrefix(ppsbase64, "sprop-pps=")
-						c.pps, _ = base64.StdEncoding.DecodeString(ppsbase64)
-						c.pps = append([]byte{0x00, 0x00, 0x00, 0x01}, c.pps...)
+				format := rtpFormat{pt: pt, codec: codec}
+				format.decoder, _ = createRtpPayloadByName(rtpmap.EncodingName)
+				if format.decoder != nil {
+					format.decoder.setOnPacket(func(data []byte, timestamp uint32, marker bool) {
+						if codec.isVideo() {
+							c.onVideo(track, codec, data, timestamp, marker)
+						} else {
+							c.onAudio(track, codec, data, timestamp)
+						}
+					})
+				}
+				//the first format the client can decode is the one of the track
+				if len(mediaTrans.formats) == 0 {
+					mediaTrans.pt = pt
+					mediaTrans.clockRate = rtpmap.ClockRate
+					mediaTrans.codec = codec
+					if codec.isVideo() {
+						c.vcid = codec
+					} else {
+						c.acid = codec
 					}
+					fmtp, _ := media.FmtpOf(pt)
+					c.parameterSets(&mediaTrans, fmtp.Params)
 				}
-				c.vcid = H265
-			} else {
-				return errors.New("UnSupport Video Codec")
-			}
-			if mediaTrans.rtpdecoder != nil {
-				mediaTrans.rtpdecoder.setOnPacket(c.onVideo)
+				mediaTrans.formats = append(mediaTrans.formats, format)
 			}
-		} else if c.sdp.Medias[i].describe.media == "audio" {
-			if c.sdp.Medias[i].rtpmap.encodeName == "PCMA" {
-				c.acid = G711A
-			} else if c.sdp.Medias[i].rtpmap.encodeName == "PCMU" {
-				c.acid = G711U
-			} else if c.sdp.Medias[i].rtpmap.encodeName == "mpeg4-generic" || c.sdp.Medias[i].rtpmap.encodeName == "MPEG4-GENERIC" {
-				c.acid = AAC
-			} else {
+			if len(mediaTrans.formats) == 0 {
+				if media.Media == "video" {
+					return errors.New("UnSupport Video Codec")
+				}
 				continue
 			}
-			if mediaTrans.rtpdecoder != nil {
-				mediaTrans.rtpdecoder.setOnPacket(c.onAudio)
+			if media.Media == "video" {
+				mediaTrans.au = new(accessUnit)
 			}
-		} else {
-			continue
 		}
 
 		var absoluteUrl string
-		if strings.HasPrefix(c.sdp.Medias[i].controlurl, "rtsp://") {
-			absoluteUrl = c.sdp.Medias[i].controlurl
-		} else if c.sdp.Medias[i].controlurl == "*" {
+		if strings.HasPrefix(media.Control, "rtsp://") {
+			absoluteUrl = media.Control
+		} else if media.Control == "*" {
 			absoluteUrl = baseurl
 		} else {
-			if strings.HasPrefix(c.sdp.Medias[i].controlurl, "/") {
-				absoluteUrl = baseurl + c.sdp.Medias[i].controlurl[1:]
+			if strings.HasPrefix(media.Control, "/") {
+				absoluteUrl = baseurl + media.Control[1:]
 			} else {
-				absoluteUrl = baseurl + c.sdp.Medias[i].controlurl
+				absoluteUrl = baseurl + media.Control
 			}
 		}
 		mediaTrans.uri = absoluteUrl
-		c.mediaChanel = append(c.mediaChanel, mediaTrans)
-	}
-	fmt.Println(len(c.mediaChanel))
-	req := MakeSetup(c.mediaChanel[c.setupStep].uri)
-	c.auth.method = "SETUP"
-	c.auth.uri = c.mediaChanel[c.setupStep].uri
-	if c.needAuth {
-		authinfo := c.auth.digestInfo()
-		req.HeaderFileds["Authorization"] = authinfo
-	}
-	req.HeaderFileds["CSeq"] = strconv.Itoa(c.cseq)
-	tcptransport := TcpTransport{Mode: "PLAY", Interleaved: [2]int{c.setupStep * 2, c.setupStep*2 + 1}}
-	req.HeaderFileds["Transport"] = tcptransport.ToString()
-	c.cseq++
-	c.handleReponse = c.handleSetup
-	return c.sendRtspCommad([]byte(req.ToString()))
+		medias = append(medias, mediaTrans)
+	}
+	c.logDebug("tracks described", "tracks", len(medias))
+	c.statsMtx.Lock()
+	carryStats(c.mediaChanel, medias)
+	c.mediaChanel = medias
+	c.statsMtx.Unlock()
+	return nil
 }
 
-func (c *Rtspclient) handleSetup(res Response) error {
-
-	if res.StatusCode == "401" {
-		return c.handleUnauthorized("SETUP", res)
-	}
-	trans, ok := res.HeaderFileds["Transport"]
-	if !ok {
+func (c *Rtspclient) applySetup(track int, res Response) error {
+	if !res.Header.Has("Transport") {
 		return errors.New("response has no Transport")
 	}
-	sessionid, ok := res.HeaderFileds["Session"]
-	if !ok {
+	if !res.Header.Has("Session") {
 		return errors.New("response has no Session")
 	}
-	timeoutIdx := strings.Index(sessionid, "timeout=")
-	if timeoutIdx > 0 {
-		timeout, _ := strconv.Atoi(strings.TrimSpace(sessionid[timeoutIdx+8:]))
-		c.session = sessionid[:timeoutIdx]
-		c.aliveTimeout = timeout
-	} else {
-		c.session = sessionid
-	}
-
-	var tcptrans TcpTransport
-	tcptrans.Parser(trans)
-	c.mediaChanel[c.setupStep].RtpChannel = tcptrans.Interleaved[0]
-	c.mediaChanel[c.setupStep].RtcpChannel = tcptrans.Interleaved[1]
-	c.setupStep++
-
-	if c.setupStep >= len(c.mediaChanel) {
-		playreq := MakePlay(c.url)
-		playreq.HeaderFileds["CSeq"] = strconv.Itoa(c.cseq)
-		playreq.HeaderFileds["Session"] = c.session
-		c.cseq++
-		c.handleReponse = c.handlePlay
-		c.auth.uri = c.url
-		c.auth.method = "PLAY"
-		if c.needAuth {
-			authinfo := c.auth.digestInfo()
-			playreq.HeaderFileds["Authorization"] = authinfo
-		}
-		return c.sendRtspCommad([]byte(playreq.ToString()))
-	} else {
-		setupreq := MakeSetup(c.mediaChanel[c.setupStep].uri)
-		setupreq.HeaderFileds["CSeq"] = strconv.Itoa(c.cseq)
-		setupreq.HeaderFileds["Session"] = c.session
-		tcptransport := TcpTransport{Mode: "PLAY", Interleaved: [2]int{c.setupStep * 2, c.setupStep*2 + 1}}
-		setupreq.HeaderFileds["Transport"] = tcptransport.ToString()
-		c.cseq++
-		c.handleReponse = c.handleSetup
-		c.auth.uri = c.mediaChanel[c.setupStep].uri
-		c.auth.method = "SETUP"
-		if c.needAuth {
-			authinfo := c.auth.digestInfo()
-			setupreq.HeaderFileds["Authorization"] = authinfo
-		}
-		return c.sendRtspCommad([]byte(setupreq.ToString()))
+	session, err := ParseSession(res.Header.Get("Session"))
+	if err != nil {
+		return err
 	}
-}
+	c.mtx.Lock()
+	c.session = session.ID
+	c.updateLogFields(c.url, session.ID)
+	if session.Timeout > 0 {
+		c.aliveTimeout = session.Timeout
+	}
+	c.mtx.Unlock()
 
-func (c *Rtspclient) handlePlay(res Response) error {
-	if res.StatusCode == "200" {
-		c.keepAlive = true
-		go func() {
-			fmt.Println("alive time out ", c.aliveTimeout)
-			c.aliveTicker = time.NewTicker(time.Second * time.Duration(c.aliveTimeout/2))
-			defer c.aliveTicker.Stop()
-			cc := c.aliveTicker.C
-			for !c.stopFlag {
-				<-cc
-				if c.stopFlag {
-					continue
-				}
-				option := MakeOption(c.url)
-				option.HeaderFileds["CSeq"] = strconv.Itoa(c.cseq)
-				option.HeaderFileds["Session"] = c.session
-				c.cseq++
-				c.handleReponse = c.handleOption
-				c.auth.uri = c.url
-				c.auth.method = "OPTIONS"
-				if c.needAuth {
-					authinfo := c.auth.digestInfo()
-					option.HeaderFileds["Authorization"] = authinfo
-				}
-				if err := c.sendRtspCommad([]byte(option.ToString())); err != nil {
-					fmt.Println("send KeepAlive Command Failed ", err)
-				}
-			}
-		}()
-		fmt.Println("play ok")
-	} else {
-		fmt.Println(res.StatusCode)
+	//the server answers with the one alternative it picked
+	transports, err := ParseTransports(res.Header.Get("Transport"))
+	if err != nil {
+		return err
 	}
+	if !transports[0].IsTcp() || !transports[0].HasInterleaved {
+		return fmt.Errorf("%s: %w", transports[0].String(), ErrUnsupportedTransport)
+	}
+	c.statsMtx.Lock()
+	c.mediaChanel[track].RtpChannel = transports[0].Interleaved[0]
+	c.mediaChanel[track].RtcpChannel = transports[0].Interleaved[1]
+	c.statsMtx.Unlock()
+	c.parseMediaHeaders(res)
+	c.selectTrack(track)
 	return nil
 }
 
-func (c *Rtspclient) handleTearDown(res Response) error {
-	c.Stop()
+func (c *Rtspclient) handlePlay(res Response) error {
+	c.parsePlayResponse(res)
+	c.mtx.Lock()
+	//a seek, a resume or a PLAY after the end of stream keeps the keepalive loop
+	c.playing = true
+	c.paused = false
+	if c.keepAlive {
+		c.mtx.Unlock()
+		return nil
+	}
+	c.keepAlive = true
+	quit := c.quit
+	strategy := c.keepAliveStrategy()
+	interval := c.keepAliveInterval()
+	c.mtx.Unlock()
+	if c.backchannel != nil {
+		c.backchannel.start(c.mediaChanel)
+	}
+	go c.keepAliveLoop(quit, strategy, interval)
+	c.logInfo("playing")
+	c.setState(StatePlaying, nil)
 	return nil
 }
 
-func (c *Rtspclient) handleUnauthorized(method string, res Response) error {
-	c.needAuth = true
-	authstr, ok := res.HeaderFileds["WWW-Authenticate"]
-	if !ok {
-		return errors.New("has no fileds WWW-Authenticate")
-	}
-	c.auth.method = method
-	var authinfo string
-	authstr = strings.TrimSpace(authstr)
-	if strings.HasPrefix(authstr, "Digest") {
-		c.auth.parse(strings.TrimPrefix(authstr, "Digest"))
-		authinfo = c.auth.digestInfo()
-	} else {
-		return errors.New("Unsupport auth")
-	}
-
-	var req Request
-	switch method {
-	case "OPTIONS":
-		req = MakeOption(c.auth.uri)
-	case "DESCRIBE":
-		req = MakeDescribe(c.auth.uri)
-	case "SETUP":
-		req = MakeSetup(c.auth.uri)
-	case "PLAY":
-		req = MakePlay(c.auth.uri)
-	}
-	req.HeaderFileds["Authorization"] = authinfo
-	req.HeaderFileds["CSeq"] = strconv.Itoa(c.cseq)
-	if c.session != "" {
-		req.HeaderFileds["Session"] = c.session
+// a PLAY after seek resets every track to the seq/rtptime in RTP-Info
+func (c *Rtspclient) parsePlayResponse(res Response) {
+	c.parseMediaHeaders(res)
+	if rangestr := res.Header.Get("Range"); rangestr != "" {
+		if rng, err := ParseRange(rangestr); err == nil {
+			c.mtx.Lock()
+			c.playRange = rng
+			c.mtx.Unlock()
+		}
+	}
+	rtpinfo := res.Header.Get("RTP-Info")
+	if rtpinfo == "" {
+		return
+	}
+	infos, err := ParseRtpInfo(rtpinfo)
+	if err != nil {
+		c.logWarn("wrong RTP-Info", "error", err)
+		return
+	}
+	for _, info := range infos {
+		for i := range c.mediaChanel {
+			media := &c.mediaChanel[i]
+			if !sameControlUrl(media.uri, info.Url) {
+				continue
+			}
+			for _, format := range media.formats {
+				if format.decoder != nil {
+					format.decoder.reset()
+				}
+			}
+			if media.au != nil {
+				media.au.reset()
+			}
+			if c.keepAlive {
+				media.discontinue = true
+			}
+			if info.HasRtpTime {
+				c.rebaseTimeline(i, info.RtpTime, c.keepAlive)
+			}
+			media.replayExt = nil
+			media.waitSeq = info.HasSeq
+			media.startSeq = info.Seq
+		}
 	}
-	c.cseq++
-	return c.sendRtspCommad([]byte(req.ToString()))
 }
 
-func (c *Rtspclient) onVideo(videoData []byte, timestamp uint32) {
+// the url in RTP-Info may be absolute or relative to the aggregate url
+func sameControlUrl(trackurl string, infourl string) bool {
+	if trackurl == infourl {
+		return true
+	}
+	trackurl = strings.TrimSuffix(trackurl, "/")
+	infourl = strings.TrimSuffix(infourl, "/")
+	return strings.HasSuffix(trackurl, "/"+strings.TrimPrefix(infourl, "/"))
+}
 
-	naluhdr, err := getNaluHdr(videoData)
+// a 401 to a request which already carried credentials is an auth failure,
+// unless the digest nonce was just stale, which is retried once per request
+func (c *Rtspclient) parseChallenge(p *pendingRequest, res Response) error {
+	req := p.req
+	challenges := res.Header.Values("WWW-Authenticate")
+	if len(challenges) == 0 {
+		return fmt.Errorf("has no fileds WWW-Authenticate: %w", ErrUnauthorized)
+	}
+	if c.username == "" {
+		return fmt.Errorf("server requires authentication but url has no username: %w", ErrUnauthorized)
+	}
+	auth, err := newAuthenticate(challenges, c.username, c.password)
 	if err != nil {
-		return
+		return fmt.Errorf("%s: %w", err.Error(), ErrUnauthorized)
 	}
-	var videoFrame Frame
-	if c.vcid == H264 {
-		nalutype := naluhdr & 0x1F
-		switch nalutype {
-		case 5:
-			frame := append(c.sps, c.pps...)
-			frame = append(frame, videoData...)
-			videoFrame = Frame{Cid: c.vcid, Data: frame, Ts: timestamp, IsKey: true}
-		case 7:
-			if !bytes.Equal(c.sps, videoData) {
-				c.sps = make([]byte, len(videoData))
-				copy(c.sps, videoData)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if sent := req.Header.Has("Authorization"); sent && c.auth != nil && c.auth.scheme() == auth.scheme() {
+		digest, isDigest := auth.(*DigestAuthenticate)
+		if !isDigest || !digest.stale || p.staleRetried {
+			return fmt.Errorf("%s: %w", req.Method, ErrUnauthorized)
+		}
+		p.staleRetried = true
+	}
+	c.auth = auth
+	return nil
+}
+
+// parameterSets parses the fmtp of the track, the parameter sets are put
+// in front of the key frames
+func (c *Rtspclient) parameterSets(media *meidaTransport, fmtp string) {
+	var err error
+	switch media.codec {
+	case H264:
+		var h264 H264Params
+		if h264, err = ParseH264Params(fmtp); err == nil {
+			media.h264 = &h264
+			if len(h264.SPS) > 0 {
+				c.sps = annexb(h264.SPS)
+				media.video, media.hasVideo = videoInfo(H264, h264.SPS[0], nil)
 			}
-		case 8:
-			if !bytes.Equal(c.pps, videoData) {
-				c.pps = make([]byte, len(videoData))
-				copy(c.pps, videoData)
+			if len(h264.PPS) > 0 {
+				c.pps = annexb(h264.PPS)
 			}
-		default:
-			videoFrame = Frame{Cid: c.vcid, Data: videoData, Ts: timestamp, IsKey: false}
 		}
-	} else if c.vcid == H265 {
-		nalutype := (naluhdr >> 1) & 0x3F
-		switch {
-		case (nalutype >= 16 && nalutype <= 21):
-			frame := append(c.vps, c.sps...)
-			frame = append(frame, c.pps...)
-			frame = append(frame, videoData...)
-			videoFrame = Frame{Cid: c.vcid, Data: frame, Ts: timestamp, IsKey: true}
-		case nalutype == 32:
-			if !bytes.Equal(c.vps, videoData) {
-				c.vps = make([]byte, len(videoData))
-				copy(c.vps, videoData)
+	case H265:
+		var h265 H265Params
+		if h265, err = ParseH265Params(fmtp); err == nil {
+			media.h265 = &h265
+			if len(h265.VPS) > 0 {
+				c.vps = annexb(h265.VPS)
 			}
-		case nalutype == 33:
-			if !bytes.Equal(c.sps, videoData) {
-				c.sps = make([]byte, len(videoData))
-				copy(c.sps, videoData)
+			if len(h265.SPS) > 0 {
+				c.sps = annexb(h265.SPS)
+				var vps []byte
+				if len(h265.VPS) > 0 {
+					vps = h265.VPS[0]
+				}
+				media.video, media.hasVideo = videoInfo(H265, h265.SPS[0], vps)
 			}
-		case nalutype == 34:
-			if !bytes.Equal(c.pps, videoData) {
-				c.pps = make([]byte, len(videoData))
-				copy(c.pps, videoData)
+			if len(h265.PPS) > 0 {
+				c.pps = annexb(h265.PPS)
 			}
-		default:
-			videoFrame = Frame{Cid: c.vcid, Data: videoData, Ts: timestamp, IsKey: false}
+		}
+	case AAC:
+		var aac AACParams
+		if aac, err = ParseAACParams(fmtp); err == nil {
+			media.aac = &aac
 		}
 	}
-	if c.OnFrame != nil {
-		c.OnFrame(videoFrame)
+	if err != nil {
+		c.logWarn("wrong fmtp", "media", media.media, "error", err)
 	}
 }
 
-func (c *Rtspclient) onAudio(audioData []byte, timestamp uint32) {
-	videoFrame := Frame{Cid: c.vcid, Data: audioData, Ts: timestamp, IsKey: true}
-	if c.OnFrame != nil {
-		c.OnFrame(videoFrame)
+// videoInfo reads the sps, and the frame rate of the vps of H265 if the sps has none
+func videoInfo(codec Codec, sps []byte, vps []byte) (VideoInfo, bool) {
+	var info VideoInfo
+	var err error
+	if codec == H264 {
+		info, err = ParseH264SPS(sps)
+	} else {
+		info, err = ParseH265SPS(sps)
+		if err == nil && info.FrameRate == 0 && len(vps) > 0 {
+			if vpsInfo, err := ParseH265VPS(vps); err == nil {
+				info.FrameRate = vpsInfo.FrameRate
+			}
+		}
+	}
+	if err != nil {
+		packageLogger().Debug("wrong sps", "codec", codec, "error", err)
+		return VideoInfo{}, false
+	}
+	return info, true
+}
+
+// updateVideoInfo follows an in-band sps that differs from the last one
+func (c *Rtspclient) updateVideoInfo(track int, codec Codec, sps []byte) {
+	info, ok := videoInfo(codec, sps, c.vps)
+	if !ok {
+		return
+	}
+	c.statsMtx.Lock()
+	media := &c.mediaChanel[track]
+	changed := !media.hasVideo || media.video != info
+	media.video, media.hasVideo = info, true
+	c.statsMtx.Unlock()
+	if changed {
+		c.logInfo("video format", "track", track, "codec", codec, "width", info.Width, "height", info.Height, "fps", info.FrameRate)
+	}
+}
+
+// VideoInfo is read from the sps of the sdp, then from the sps in the stream
+func (c *Rtspclient) VideoInfo(track int) (VideoInfo, bool) {
+	c.statsMtx.Lock()
+	defer c.statsMtx.Unlock()
+	if track < 0 || track >= len(c.mediaChanel) {
+		return VideoInfo{}, false
+	}
+	return c.mediaChanel[track].video, c.mediaChanel[track].hasVideo
+}
+
+// annexb puts a start code in front of every nal unit
+func annexb(nalus [][]byte) []byte {
+	var data []byte
+	for _, nalu := range nalus {
+		data = append(data, 0x00, 0x00, 0x00, 0x01)
+		data = append(data, nalu...)
+	}
+	return data
+}
+
+func (c *Rtspclient) onAudio(track int, codec Codec, audioData []byte, timestamp uint32) {
+	audioFrame := Frame{Cid: codec, Data: audioData, Ts: timestamp, IsKey: true}
+	c.fillReplayInfo(track, &audioFrame)
+	c.adjustTimestamp(track, &audioFrame)
+	c.countFrame(track, audioFrame)
+	c.deliver(audioFrame)
+}
+
+// the replay extension is only carried by the first packet of an access unit,
+// frames without their own extension are placed relative to the last one.
+// Discontinuity is also set on the first frame after a seek
+func (c *Rtspclient) fillReplayInfo(track int, frame *Frame) {
+	media := &c.mediaChanel[track]
+	frame.Discontinuity = media.discontinue
+	media.discontinue = false
+	if media.replayExt == nil {
+		return
+	}
+	frame.NtpTime = media.replayExt.NtpTime
+	if media.clockRate > 0 {
+		delta := int64(int32(frame.Ts - media.replayTs))
+		frame.NtpTime = frame.NtpTime.Add(time.Duration(delta * int64(time.Second) / int64(media.clockRate)))
 	}
 }
 
 func BuildRtspClient(rtspurl string) *Rtspclient {
 	client := new(Rtspclient)
-	client.url = rtspurl
-	tmpurl, err := url.Parse(rtspurl)
-	if err != nil {
+	if err := client.setUrl(rtspurl); err != nil {
 		return nil
 	}
-	if strings.ToLower(tmpurl.Scheme) == "rtsps" {
-		client.secure = true
+	client.aliveTimeout = 60
+	client.version = RTSP10
+	client.keepAlive = false
+	client.rtcpSsrc = rand.Uint32()
+	client.done = make(chan struct{})
+	client.logger = packageLogger()
+	return client
+}
+
+// setUrl keeps the credentials if rtspurl has none, like a redirect Location
+func (c *Rtspclient) setUrl(rtspurl string) error {
+	tmpurl, err := url.Parse(rtspurl)
+	if err != nil {
+		return err
 	}
-	client.host = tmpurl.Host
+	c.secure = strings.ToLower(tmpurl.Scheme) == "rtsps"
+	c.host = tmpurl.Host
 	if tmpurl.Port() == "" {
-		client.host += ":554"
+		c.host += ":554"
 	}
 	if tmpurl.User != nil {
-		client.username = tmpurl.User.Username()
-		client.password, _ = tmpurl.User.Password()
+		c.username = tmpurl.User.Username()
+		c.password, _ = tmpurl.User.Password()
 	}
 	tmpurl.User = nil
-	client.url = tmpurl.String()
-	client.aliveTimeout = 60
-	client.auth.password = client.password
-	client.auth.username = client.username
-	client.needAuth = false
-	client.keepAlive = false
-	return client
+	c.url = tmpurl.String()
+	c.updateLogFields(c.url, "")
+	return nil
 }
 
+// Start connects and plays every track without waiting, errors are only printed.
+// Dial, Describe, Setup and Play do the same and return the errors
 func (c *Rtspclient) Start() {
-	if c.secure {
-		fmt.Println("start rtsps")
-		conf := &tls.Config{
-			InsecureSkipVerify: true,
-		}
-		conn, err := tls.Dial("tcp", c.host, conf)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		c.conn = conn
-	} else {
-		conn, err := net.DialTimeout("tcp", c.host, time.Second*5)
-		if err != nil {
-			fmt.Println("connect failed " + err.Error())
-			return
-		}
-		c.conn = conn
+	if c.claimSupervision() {
+		c.markStart()
+		go c.run()
+		return
 	}
-
-	c.recvBuf = new(bytes.Buffer)
-	c.stopFlag = false
-	c.handleReponse = c.handleOption
-	c.cseq = 1
-	c.session = ""
-	req := MakeOption(c.url)
-	req.HeaderFileds["CSeq"] = strconv.Itoa(c.cseq)
-	c.cseq++
-	c.auth.uri = c.url
-	err := c.sendRtspCommad([]byte(req.ToString()))
-	if err != nil {
+	c.markStart()
+	c.setState(StateConnecting, nil)
+	if err := c.connect(context.Background()); err != nil {
+		c.logError("connect failed", "error", err)
+		c.finish(err)
 		return
 	}
-	go c.cycleRecv()
+	go c.handshake()
 }
 
 func (c *Rtspclient) Stop() {
-	if !c.stopFlag {
-		c.stopFlag = true
-		c.aliveTicker.Reset(time.Millisecond * 10)
-		tearDown := MakeTearDown(c.url)
-		tearDown.HeaderFileds["CSeq"] = strconv.Itoa(c.cseq)
-		c.cseq++
-		c.auth.uri = c.url
-		c.auth.method = "TEARDOWN"
-		if c.needAuth {
-			authinfo := c.auth.digestInfo()
-			tearDown.HeaderFileds["Authorization"] = authinfo
+	c.stopWith(nil)
+}
+
+// stopWith may be called by the caller and by the receive goroutine at the
+// same time, only the first call sends TEARDOWN
+func (c *Rtspclient) stopWith(err error) {
+	c.stopOnce.Do(func() {
+		c.mtx.Lock()
+		c.stopped = true
+		connected := c.conn != nil && !c.stopFlag
+		c.mtx.Unlock()
+		if connected {
+			c.sendRequestWith(MakeTearDown(c.url), nil)
+			c.closeConn()
 		}
-		c.sendRtspCommad([]byte(tearDown.ToString()))
+		c.finish(err)
+	})
+}
+
+// finish closes Done once, the client is not used anymore
+func (c *Rtspclient) finish(err error) {
+	c.mtx.Lock()
+	if c.finished {
+		c.mtx.Unlock()
+		return
+	}
+	c.finished = true
+	c.stopped = true
+	frames := c.frames
+	c.mtx.Unlock()
+	c.setState(StateStopped, err)
+	close(c.done)
+	if frames != nil {
+		frames.close()
+	}
+}
+
+func (c *Rtspclient) isStopped() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.stopped
+}
+
+func (c *Rtspclient) lostErr() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lostError
+}
+
+// closeConn wakes every waiting request and ends cycleRecv
+func (c *Rtspclient) closeConn() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.conn != nil && !c.stopFlag {
+		c.stopFlag = true
+		close(c.quit)
 		c.conn.Close()
 	}
 }
 
-func (c *Rtspclient) cycleRecv() {
-	defer c.Stop()
-	for !c.stopFlag {
+func (c *Rtspclient) currentConn(conn net.Conn) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.conn == conn
+}
+
+// connQuit is closed when the current connection is closed
+func (c *Rtspclient) connQuit() chan struct{} {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.quit
+}
+
+// connectionLost leaves a supervised connection to the reconnect loop
+func (c *Rtspclient) connectionLost(err error) {
+	if c.isStopped() {
+		//closed by Stop, which finishes the client itself
+		return
+	}
+	c.mtx.Lock()
+	supervised := c.reconnect != nil && c.supervised
+	if supervised {
+		c.lostError = err
+		c.playing = false
+	}
+	c.mtx.Unlock()
+	if supervised {
+		c.closeConn()
+		return
+	}
+	c.stopWith(err)
+}
+
+// claimSupervision is true once, for the caller which starts supervise
+func (c *Rtspclient) claimSupervision() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.reconnect == nil || c.supervised {
+		return false
+	}
+	c.supervised = true
+	return true
+}
+
+func (c *Rtspclient) selectTrack(track int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, selected := range c.selected {
+		if selected == track {
+			return
+		}
+	}
+	c.selected = append(c.selected, track)
+}
+
+func (c *Rtspclient) cycleRecv(conn net.Conn, done chan struct{}) {
+	var err error
+	defer close(done)
+	defer func() {
+		//a redirect has already replaced the connection
+		if c.currentConn(conn) {
+			c.connectionLost(err)
+		}
+	}()
+	c.recvBuf = new(bytes.Buffer)
+	for {
 		buf := make([]byte, 4096)
-		readLen, err := c.conn.Read(buf)
+		var readLen int
+		readLen, err = conn.Read(buf)
 		if err != nil {
-			fmt.Println(err)
+			c.logInfo("connection closed", "error", err)
 			return
 		}
 		c.recvBuf.Write(buf[:readLen])
@@ -542,7 +783,7 @@ func (c *Rtspclient) cycleRecv() {
 				needMore, err = c.handleRtspMessage()
 			}
 			if err != nil {
-				fmt.Println(err)
+				c.logError("receive failed", "error", err)
 				return
 			}
 		}
@@ -558,13 +799,40 @@ func (c *Rtspclient) rtpOverRtsp() (bool, error) {
 	if c.recvBuf.Len() < int(rtppacketlen)+4 {
 		return true, nil
 	}
-	//fmt.Printf("rtp channel=%d, rtp size:%d\n", channel, rtppacketlen)
+	now := time.Now()
 	for i := 0; i < len(c.mediaChanel); i++ {
+		if c.mediaChanel[i].RtcpChannel == int(channel) {
+			c.handleRtcp(i, c.recvBuf.Bytes()[4:4+rtppacketlen], now)
+			continue
+		}
 		if c.mediaChanel[i].RtpChannel == int(channel) {
-			if c.mediaChanel[i].rtpdecoder == nil {
+			packet := c.recvBuf.Bytes()[4 : 4+rtppacketlen]
+			if !c.mediaChanel[i].checkSeq(packet) {
 				continue
 			}
-			c.mediaChanel[i].rtpdecoder.decode(c.recvBuf.Bytes()[4 : 4+rtppacketlen])
+			c.statsMtx.Lock()
+			c.mediaChanel[i].updateSeq(packet, now)
+			c.statsMtx.Unlock()
+			if len(packet) < 2 {
+				continue
+			}
+			//packets of a payload type the sdp did not negotiate are dropped
+			format := c.mediaChanel[i].format(int(packet[1] & 0x7F))
+			if format == nil {
+				c.logDebug("unexpected payload type", "track", i, "pt", packet[1]&0x7F)
+				continue
+			}
+			if format.decoder == nil {
+				continue
+			}
+			//the picture before is complete, even if its marker got lost
+			if au := c.mediaChanel[i].au; au != nil && au.hasSlice && len(packet) >= 12 && au.ts != binary.BigEndian.Uint32(packet[4:8]) {
+				c.flushAccessUnit(i)
+			}
+			if c.replay != nil {
+				c.updateReplayInfo(i, packet)
+			}
+			format.decoder.decode(packet)
 		}
 	}
 	c.recvBuf.Next(int(4 + rtppacketlen))
@@ -572,6 +840,12 @@ func (c *Rtspclient) rtpOverRtsp() (bool, error) {
 }
 
 func (c *Rtspclient) handleRtspMessage() (bool, error) {
+	if c.recvBuf.Len() < 5 {
+		return true, nil
+	}
+	if !bytes.HasPrefix(c.recvBuf.Bytes(), []byte("RTSP/")) {
+		return c.handleServerMessage()
+	}
 	var res Response
 	state := res.Decode(c.recvBuf.Bytes())
 	if state == Failed {
@@ -580,24 +854,262 @@ func (c *Rtspclient) handleRtspMessage() (bool, error) {
 		return true, nil
 	}
 
-	if res.StatusCode != "200" && res.StatusCode != "401" {
-		return false, errors.New("statuscode is " + res.StatusCode)
-	}
 	c.recvBuf.Next(res.TotalLen)
-	fmt.Println(res.ToString())
-	return false, c.handleReponse(res)
+	c.logDebug("recv response", "status", res.StatusCode, "reason", res.Reason, "cseq", res.Header.Get("CSeq"))
+	return false, c.dispatchResponse(res)
+}
+
+func (m *meidaTransport) checkSeq(packet []byte) bool {
+	if !m.waitSeq || len(packet) < 12 {
+		return true
+	}
+	seq := uint16(packet[2])<<8 | uint16(packet[3])
+	if int16(seq-m.startSeq) < 0 {
+		return false
+	}
+	m.waitSeq = false
+	return true
+}
+
+func (m *meidaTransport) updateSeq(packet []byte, now time.Time) {
+	if len(packet) < 12 {
+		return
+	}
+	seq := uint16(packet[2])<<8 | uint16(packet[3])
+	m.ssrc = getUint32(packet[8:])
+	m.packetsReceived++
+	m.stats.packets++
+	m.stats.bytes += uint64(len(packet))
+	m.stats.roll(now)
+	m.updateJitter(getUint32(packet[4:]), now)
+	if !m.received {
+		m.received = true
+		m.highestSeq = uint32(seq)
+		m.baseSeq = uint32(seq)
+		return
+	}
+	cycles := m.highestSeq & 0xFFFF0000
+	if delta := int16(seq - uint16(m.highestSeq)); delta > 0 {
+		if seq < uint16(m.highestSeq) {
+			cycles += 1 << 16
+		}
+		m.highestSeq = cycles | uint32(seq)
+	} else if delta < 0 {
+		m.stats.outOfOrder++
+	}
+}
+
+func (c *Rtspclient) updateReplayInfo(track int, packet []byte) {
+	var rtppacket rtp
+	if rtppacket.decode(packet) != nil {
+		return
+	}
+	ext, ok := rtppacket.onvifReplayExtension()
+	if !ok {
+		return
+	}
+	media := &c.mediaChanel[track]
+	media.replayExt = &ext
+	media.replayTs = rtppacket.head.timestamp
+	if ext.Discontinuity {
+		media.discontinue = true
+	}
+}
+
+// EnablePreemptiveBasicAuth sends the url credentials with basic auth from the
+// first request on, it must be called before Start
+func (c *Rtspclient) EnablePreemptiveBasicAuth() {
+	c.basicAuth = true
+}
+
+// EnableOnvifReplay must be called before Start
+func (c *Rtspclient) EnableOnvifReplay(replay OnvifReplay) {
+	c.replay = &replay
+}
+
+func (c *Rtspclient) addRequireHeader(req *Request) {
+	if req.Method != "DESCRIBE" && req.Method != "SETUP" && req.Method != "PLAY" {
+		return
+	}
+	var tags FeatureTags
+	if c.replay != nil {
+		tags = append(tags, "onvif-replay")
+	}
+	if c.backchannel != nil {
+		tags = append(tags, onvifBackChannelTag)
+	}
+	if len(tags) > 0 {
+		req.Header.Set("Require", tags.String())
+	}
+}
+
+func (c *Rtspclient) addReplayHeaders(req *Request) {
+	if c.replay == nil {
+		return
+	}
+	if req.Method != "PLAY" {
+		return
+	}
+	if !req.Header.Has("Range") {
+		req.Header.Set("Range", ClockRange(c.replay.Start, c.replay.End).String())
+	}
+	if c.replay.RateControl {
+		req.Header.Set("Rate-Control", "yes")
+	} else {
+		req.Header.Set("Rate-Control", "no")
+	}
+	if c.replay.Immediate {
+		req.Header.Set("Immediate", "yes")
+	}
+	if c.replay.Frames != "" {
+		req.Header.Set("Frames", c.replay.Frames)
+	}
+}
+
+func (c *Rtspclient) prepareRequest(req *Request) int {
+	cseq := c.cseq
+	c.cseq++
+	req.Version = c.version
+	req.Header.Set("CSeq", strconv.Itoa(cseq))
+	if c.session != "" {
+		req.Header.Set("Session", c.session)
+	}
+	if c.auth != nil {
+		req.Header.Set("Authorization", c.auth.authorization(req.Method, req.Uri))
+	}
+	c.addRequireHeader(req)
+	c.addReplayHeaders(req)
+	c.addVersionHeaders(req)
+	return cseq
 }
 
 func (c *Rtspclient) sendRtspCommad(msg []byte) error {
-	fmt.Println("send commad " + string(msg))
+	return c.write(msg)
+}
+
+func (c *Rtspclient) write(msg []byte) error {
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+	if c.conn == nil {
+		return errors.New("rtsp client is not connected")
+	}
+	//a server which stops reading must not block the writers holding mtx forever
+	c.conn.SetWriteDeadline(time.Now().Add(c.requestTimeout()))
 	var wlen int = 0
 	for wlen < len(msg) {
-		sendlen, werr := c.conn.Write(msg)
+		sendlen, werr := c.conn.Write(msg[wlen:])
 		if werr != nil {
-			fmt.Println("write Failed" + werr.Error())
+			c.logError("write failed", "error", werr)
 			return errors.New("send rtsp commad faild")
 		}
 		wlen += sendlen
 	}
 	return nil
 }
+
+func (c *Rtspclient) isPlaying() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.playing
+}
+
+func (c *Rtspclient) makePlay() Request {
+	req := MakePlay(c.url)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.scale != 0 {
+		req.Header.Set("Scale", strconv.FormatFloat(c.scale, 'f', -1, 64))
+	}
+	if c.speed != 0 {
+		req.Header.Set("Speed", strconv.FormatFloat(c.speed, 'f', -1, 64))
+	}
+	return req
+}
+
+// sendPlay waits for the reply, a refused PLAY returns a *StatusError and
+// leaves the session as it was. Like Play it must not be called from OnFrame
+func (c *Rtspclient) sendPlay(ctx context.Context, req Request) error {
+	c.mtx.Lock()
+	played := c.keepAlive
+	c.mtx.Unlock()
+	if !played {
+		return errors.New("rtsp session is not playing")
+	}
+	_, err := c.request(ctx, req, c.handlePlay)
+	return err
+}
+
+func (c *Rtspclient) handlePause(res Response) error {
+	c.mtx.Lock()
+	c.playing = false
+	c.paused = true
+	c.mtx.Unlock()
+	return nil
+}
+
+// Pause stops the delivery, the session is kept alive until Resume
+func (c *Rtspclient) Pause(ctx context.Context) error {
+	if !c.isPlaying() {
+		return errors.New("rtsp session is not playing")
+	}
+	_, err := c.request(ctx, MakePause(c.url), c.handlePause)
+	return err
+}
+
+// Resume continues playing from where Pause stopped
+func (c *Rtspclient) Resume(ctx context.Context) error {
+	c.mtx.Lock()
+	paused := c.paused
+	c.mtx.Unlock()
+	if !paused {
+		return errors.New("rtsp session is not paused")
+	}
+	return c.sendPlay(ctx, c.makePlay())
+}
+
+// Seek plays from a new position, rng is a npt or clock range. A paused
+// session starts playing again
+func (c *Rtspclient) Seek(ctx context.Context, rng Range) error {
+	c.mtx.Lock()
+	acceptRanges := c.acceptRanges
+	c.mtx.Unlock()
+	if len(acceptRanges) > 0 && !acceptRanges.Has(rng.Unit) {
+		return errors.New("server does not accept range unit " + rng.Unit)
+	}
+	req := c.makePlay()
+	req.Header.Set("Range", rng.String())
+	return c.sendPlay(ctx, req)
+}
+
+// SetScale changes the playback rate, 2 plays forward twice as fast, -1 plays backward
+func (c *Rtspclient) SetScale(ctx context.Context, scale float64) error {
+	req := c.makePlay()
+	req.Header.Set("Scale", strconv.FormatFloat(scale, 'f', -1, 64))
+	if err := c.sendPlay(ctx, req); err != nil {
+		return err
+	}
+	c.mtx.Lock()
+	c.scale = scale
+	c.mtx.Unlock()
+	return nil
+}
+
+// SetSpeed asks the server to deliver data faster or slower, without changing the viewing rate
+func (c *Rtspclient) SetSpeed(ctx context.Context, speed float64) error {
+	req := c.makePlay()
+	req.Header.Set("Speed", strconv.FormatFloat(speed, 'f', -1, 64))
+	if err := c.sendPlay(ctx, req); err != nil {
+		return err
+	}
+	c.mtx.Lock()
+	c.speed = speed
+	c.mtx.Unlock()
+	return nil
+}
+
+// PlayRange is the Range returned by the last PLAY
+func (c *Rtspclient) PlayRange() Range {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.playRange
+}
@@ -0,0 +1,443 @@
+package rtsp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer answers the requests of one h264 track and streams it over
+// interleaved rtp after PLAY
+type testServer struct {
+	ln      net.Listener
+	timeout int    //session timeout in seconds
+	packets int    //sent on a connection before it is closed, 0 streams until the client leaves
+	sdp     string //testServerSdp if empty
+	//answers instead of the server when it returns a response
+	reply    func(method string, header map[string]string) string
+	mtx      sync.Mutex
+	methods  map[string]int
+	conns    int
+	received map[int][][]byte //interleaved packets of the client by channel
+}
+
+const testServerSdp = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=test\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z0IAH5WoFAFuQA==,aM48gA==\r\n" +
+	"a=control:track1\r\n"
+
+func newTestServer(t *testing.T, timeout int, packets int) *testServer {
+	return startTestServer(t, &testServer{timeout: timeout, packets: packets})
+}
+
+func startTestServer(t *testing.T, s *testServer) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ln = ln
+	s.methods = make(map[string]int)
+	s.received = make(map[int][][]byte)
+	if s.sdp == "" {
+		s.sdp = testServerSdp
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) url() string {
+	return "rtsp://" + s.ln.Addr().String() + "/live"
+}
+
+// receivedOn returns the packets the client sent on channel
+func (s *testServer) receivedOn(channel int) [][]byte {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.received[channel]
+}
+
+func (s *testServer) count(method string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.methods[method]
+}
+
+func readTestRequest(r *bufio.Reader) (string, map[string]string, error) {
+	var method string
+	header := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if method == "" {
+			method = strings.Fields(line)[0]
+		} else if kv := strings.SplitN(line, ":", 2); len(kv) == 2 {
+			header[strings.ToLower(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	length, _ := strconv.Atoi(header["content-length"])
+	_, err := io.CopyN(io.Discard, r, int64(length))
+	return method, header, err
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	s.mtx.Lock()
+	s.conns++
+	n := s.conns
+	s.mtx.Unlock()
+
+	var writeMtx sync.Mutex
+	write := func(b []byte) error {
+		writeMtx.Lock()
+		defer writeMtx.Unlock()
+		_, err := conn.Write(b)
+		return err
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	//every connection starts at another timestamp, as a restarted camera does
+	seq, ts := uint16(n*1000), uint32(n)*900000
+	streaming := false
+	setups := 0
+
+	r := bufio.NewReader(conn)
+	for {
+		//rtcp and backchannel rtp of the client
+		if b, err := r.Peek(1); err == nil && b[0] == '$' {
+			head := make([]byte, 4)
+			if _, err = io.ReadFull(r, head); err != nil {
+				return
+			}
+			packet := make([]byte, int(head[2])<<8|int(head[3]))
+			if _, err = io.ReadFull(r, packet); err != nil {
+				return
+			}
+			s.mtx.Lock()
+			s.received[int(head[1])] = append(s.received[int(head[1])], packet)
+			s.mtx.Unlock()
+			continue
+		}
+		method, header, err := readTestRequest(r)
+		if err != nil {
+			return
+		}
+		s.mtx.Lock()
+		s.methods[method]++
+		s.mtx.Unlock()
+		if s.reply != nil {
+			if res := s.reply(method, header); res != "" {
+				if err = write([]byte(res)); err != nil {
+					return
+				}
+				continue
+			}
+		}
+		res := "RTSP/1.0 200 OK\r\nCSeq: " + header["cseq"] + "\r\n"
+		switch method {
+		case "OPTIONS":
+			res += "Public: OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN, GET_PARAMETER\r\n\r\n"
+		case "DESCRIBE":
+			res += "Content-Base: " + s.url() + "/\r\nContent-Type: application/sdp\r\n" +
+				"Content-Length: " + strconv.Itoa(len(s.sdp)) + "\r\n\r\n" + s.sdp
+		case "SETUP":
+			res += "Session: 1234;timeout=" + strconv.Itoa(s.timeout) + "\r\n" +
+				fmt.Sprintf("Transport: RTP/AVP/TCP;unicast;interleaved=%d-%d\r\n\r\n", setups*2, setups*2+1)
+			setups++
+		case "PLAY":
+			res += "Session: 1234\r\nRange: npt=0-\r\n" +
+				fmt.Sprintf("RTP-Info: url=%s/track1;seq=%d;rtptime=%d\r\n\r\n", s.url(), seq, ts)
+		default:
+			res += "Session: 1234\r\n\r\n"
+		}
+		if err = write([]byte(res)); err != nil {
+			return
+		}
+		if method == "TEARDOWN" {
+			return
+		}
+		if method == "PLAY" && !streaming {
+			streaming = true
+			go s.stream(conn, write, quit, seq, ts)
+		}
+	}
+}
+
+// stream sends an idr slice per frame at 200 frames per second
+func (s *testServer) stream(conn net.Conn, write func([]byte) error, quit chan struct{}, seq uint16, ts uint32) {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for i := 0; s.packets == 0 || i < s.packets; i++ {
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+		if write(testRtpPacket(seq+uint16(i), ts+uint32(i)*450, []byte{0x65, 0x88, 0x84, 0x00})) != nil {
+			return
+		}
+	}
+	conn.Close()
+}
+
+func testRtpPacket(seq uint16, ts uint32, payload []byte) []byte {
+	packet := []byte{'$', 0, 0, 0, 0x80, 0x80 | 96, byte(seq >> 8), byte(seq),
+		byte(ts >> 24), byte(ts >> 16), byte(ts >> 8), byte(ts), 0, 0, 0, 1}
+	packet = append(packet, payload...)
+	packet[2] = byte((len(packet) - 4) >> 8)
+	packet[3] = byte(len(packet) - 4)
+	return packet
+}
+
+// eventually waits for the server to read what the client sent
+func (s *testServer) eventually(method string, n int) int {
+	deadline := time.Now().Add(2 * time.Second)
+	for s.count(method) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s.count(method)
+}
+
+func waitDone(t *testing.T, c *Rtspclient) {
+	t.Helper()
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client is not done after Stop")
+	}
+}
+
+func TestClientReconnectKeepsTimestamps(t *testing.T) {
+	s := newTestServer(t, 60, 20)
+	frames := make(chan Frame, 256)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, s.url(), &Options{
+		Reconnect: &ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond, Multiplier: 2},
+		OnFrame:   func(frame Frame) { frames <- frame },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Describe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Setup(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Play(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var last uint32
+	discontinuities := 0
+	for i := 0; i < 70; i++ {
+		var frame Frame
+		select {
+		case frame = <-frames:
+		case <-ctx.Done():
+			t.Fatalf("got %d frames", i)
+		}
+		if i > 0 && int32(frame.Ts-last) <= 0 {
+			t.Fatalf("frame %d ts %d does not follow %d", i, frame.Ts, last)
+		}
+		if frame.Discontinuity {
+			discontinuities++
+		}
+		last = frame.Ts
+	}
+	if discontinuities < 2 {
+		t.Errorf("%d discontinuities for 3 connections", discontinuities)
+	}
+	if stats := c.Stats(); stats.Reconnects < 2 {
+		t.Errorf("%d reconnects", stats.Reconnects)
+	}
+	c.Close()
+	waitDone(t, c)
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	s := newTestServer(t, 1, 0)
+	c := BuildRtspClient(s.url())
+	c.OnFrame = func(frame Frame) {}
+	c.Start()
+	time.Sleep(1600 * time.Millisecond)
+	c.Stop()
+	waitDone(t, c)
+	//twice a session timeout, GET_PARAMETER as the server lists it in Public
+	if n := s.count("GET_PARAMETER"); n < 2 {
+		t.Errorf("%d keepalives", n)
+	}
+	if n := s.eventually("TEARDOWN", 1); n != 1 {
+		t.Errorf("%d teardowns", n)
+	}
+}
+
+// TestClientConcurrentControl is meant for go test -race: the control methods,
+// the keepalives, the reconnects and Stop run at the same time
+func TestClientConcurrentControl(t *testing.T) {
+	s := newTestServer(t, 1, 60)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := BuildRtspClient(s.url())
+			c.SetKeepAliveStrategy(KeepAliveStrategy(i % 4))
+			c.SetReconnectPolicy(ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond, Multiplier: 2})
+			c.OnFrame = func(frame Frame) {}
+			c.OnStateChange = func(state State, err error) {}
+			c.Start()
+
+			stop := make(chan struct{})
+			var callers sync.WaitGroup
+			call := func(f func()) {
+				callers.Add(1)
+				go func() {
+					defer callers.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						case <-time.After(20 * time.Millisecond):
+						}
+						f()
+					}
+				}()
+			}
+			control := func(f func(ctx context.Context)) func() {
+				return func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+					f(ctx)
+					cancel()
+				}
+			}
+			call(control(func(ctx context.Context) { c.Seek(ctx, Range{Unit: "npt", Start: time.Second, End: -1}) }))
+			call(control(func(ctx context.Context) {
+				c.Pause(ctx)
+				c.Resume(ctx)
+			}))
+			call(control(func(ctx context.Context) { c.GetParameter(ctx) }))
+			call(func() {
+				c.Stats()
+				c.VideoInfo(0)
+				c.PlayRange()
+			})
+
+			time.Sleep(time.Second)
+			go c.Stop()
+			c.Stop()
+			close(stop)
+			callers.Wait()
+			waitDone(t, c)
+		}(i)
+	}
+	wg.Wait()
+	if s.count("PLAY") <= 4 {
+		t.Errorf("%d PLAY for 4 clients with seeks and reconnects", s.count("PLAY"))
+	}
+}
+
+func TestClientPlaybackControl(t *testing.T) {
+	var mtx sync.Mutex
+	var plays []map[string]string
+	s := startTestServer(t, &testServer{timeout: 60, reply: func(method string, header map[string]string) string {
+		if method != "PLAY" {
+			return ""
+		}
+		mtx.Lock()
+		plays = append(plays, header)
+		mtx.Unlock()
+		if header["range"] == "npt=3600-" || header["scale"] == "16" {
+			return "RTSP/1.0 457 Invalid Range\r\nCSeq: " + header["cseq"] + "\r\nSession: 1234\r\n\r\n"
+		}
+		return ""
+	}})
+	lastPlay := func() map[string]string {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return plays[len(plays)-1]
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, s.url(), &Options{OnFrame: func(frame Frame) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Describe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Setup(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Seek(ctx, Range{Unit: "npt", End: -1}); err == nil {
+		t.Error("seek before Play")
+	}
+	if err = c.Play(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Resume(ctx); err == nil {
+		t.Error("resume of a playing session")
+	}
+	if err = c.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Pause(ctx); err == nil || s.count("PAUSE") != 1 {
+		t.Errorf("second pause got %v, %d PAUSE sent", err, s.count("PAUSE"))
+	}
+	if err = c.Resume(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var statusErr *StatusError
+	err = c.Seek(ctx, Range{Unit: "npt", Start: time.Hour, End: -1})
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 457 {
+		t.Errorf("refused seek got %v", err)
+	}
+	if err = c.Seek(ctx, Range{Unit: "npt", Start: 10 * time.Second, End: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if rng := lastPlay()["range"]; rng != "npt=10-" {
+		t.Errorf("seek sent range %q", rng)
+	}
+
+	//a refused scale is not sent again with the next PLAY
+	if err = c.SetScale(ctx, 16); !errors.As(err, &statusErr) {
+		t.Errorf("refused scale got %v", err)
+	}
+	if err = c.SetSpeed(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if play := lastPlay(); play["scale"] != "" || play["speed"] != "2" {
+		t.Errorf("PLAY after a refused scale got %v", play)
+	}
+}
@@ -0,0 +1,305 @@
+package rtsp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	maxRedirects  = 5
+	maxRetries503 = 3
+)
+
+// Options of Dial, the zero value works like BuildRtspClient
+type Options struct {
+	Timeout             time.Duration    //of a request, 10 seconds if zero
+	Reconnect           *ReconnectPolicy //nil does not reconnect
+	DialTimeout         time.Duration    //5 seconds if zero
+	Version             string           //RTSP10 if empty
+	Pipelining          bool
+	KeepAlive           KeepAliveStrategy
+	PreemptiveBasicAuth bool
+	OnvifReplay         *OnvifReplay
+	BackChannel         bool
+	TLSConfig           *tls.Config //certificates are not verified if nil
+	OnFrame             func(frame Frame)
+	Logger              Logger //the one of SetDefaultLogger if nil
+	QueueDepth          int    //of Frames, 64 if zero
+	DropPolicy          DropPolicy
+	OnNotify            func(notify PlayNotify)
+	OnStateChange       func(state State, err error)
+}
+
+// Track is a media of the session description the client can receive
+type Track struct {
+	Index       int
+	Media       string //video or audio
+	Codec       Codec
+	PayloadType int
+	ClockRate   int
+	Control     string //absolute url of SETUP
+	BackChannel bool
+	//the fmtp of the track, nil for the other codecs
+	H264 *H264Params
+	H265 *H265Params
+	AAC  *AACParams
+	//read from the sps of the sdp, VideoInfo of the client follows the sps in the stream
+	Video    VideoInfo
+	HasVideo bool
+}
+
+// SessionDescription is the result of DESCRIBE
+type SessionDescription struct {
+	Sdp    Rtspsdp
+	Tracks []Track
+}
+
+// Dial connects to rtspurl and sends OPTIONS, the session is created by
+// Describe, Setup and Play
+func Dial(ctx context.Context, rtspurl string, opts *Options) (*Rtspclient, error) {
+	c := BuildRtspClient(rtspurl)
+	if c == nil {
+		return nil, errors.New("wrong rtsp url " + rtspurl)
+	}
+	if opts != nil {
+		if err := c.applyOptions(opts); err != nil {
+			return nil, err
+		}
+	}
+	c.markStart()
+	c.setState(StateConnecting, nil)
+	if err := c.connect(ctx); err != nil {
+		c.finish(err)
+		return nil, err
+	}
+	if _, err := c.requestFollow(ctx, func() Request { return MakeOption(c.url) }, c.handleOption); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// request is do, a 503 with Retry-After is sent again after the delay
+func (c *Rtspclient) request(ctx context.Context, req Request, handle func(res Response) error) (Response, error) {
+	quit := c.connQuit()
+	for retry := 0; ; retry++ {
+		res, err := c.do(ctx, req, handle)
+		var statusErr *StatusError
+		if retry >= maxRetries503 || !errors.As(err, &statusErr) || statusErr.StatusCode != 503 || statusErr.RetryAfter <= 0 {
+			return res, err
+		}
+		timer := time.NewTimer(statusErr.RetryAfter)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return res, ctx.Err()
+		case <-quit:
+			timer.Stop()
+			return res, ErrClosed
+		}
+	}
+}
+
+// requestFollow is request, a 3xx reconnects to Location and makes the request
+// again for the new url, only used before the session exists
+func (c *Rtspclient) requestFollow(ctx context.Context, makeReq func() Request, handle func(res Response) error) (Response, error) {
+	for redirects := 0; ; redirects++ {
+		res, err := c.request(ctx, makeReq(), handle)
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || !statusErr.Redirect() {
+			return res, err
+		}
+		if redirects >= maxRedirects {
+			return res, fmt.Errorf("%s: %w", statusErr.Error(), ErrTooManyRedirects)
+		}
+		c.logInfo("redirect", "location", statusErr.Location, "status", statusErr.StatusCode)
+		if err := c.redirect(ctx, statusErr.Location); err != nil {
+			return res, err
+		}
+	}
+}
+
+func (c *Rtspclient) redirect(ctx context.Context, location string) error {
+	target, err := url.Parse(location)
+	if err != nil {
+		return errors.New("wrong redirect location " + location)
+	}
+	if base, err := url.Parse(c.url); err == nil {
+		target = base.ResolveReference(target)
+	}
+	c.dropConn()
+	if err := c.setUrl(target.String()); err != nil {
+		return err
+	}
+	return c.connect(ctx)
+}
+
+func (c *Rtspclient) applyOptions(opts *Options) error {
+	if opts.Version != "" {
+		if err := c.SetVersion(opts.Version); err != nil {
+			return err
+		}
+	}
+	c.timeout = opts.Timeout
+	c.dialTimeout = opts.DialTimeout
+	c.pipelining = opts.Pipelining
+	c.aliveStrategy = opts.KeepAlive
+	c.basicAuth = opts.PreemptiveBasicAuth
+	c.tlsConfig = opts.TLSConfig
+	if opts.OnvifReplay != nil {
+		c.EnableOnvifReplay(*opts.OnvifReplay)
+	}
+	if opts.BackChannel {
+		c.EnableBackChannel()
+	}
+	c.OnFrame = opts.OnFrame
+	if opts.Logger != nil {
+		c.logger = opts.Logger
+	}
+	c.queueDepth = opts.QueueDepth
+	c.dropPolicy = opts.DropPolicy
+	c.OnNotify = opts.OnNotify
+	c.OnStateChange = opts.OnStateChange
+	c.reconnect = opts.Reconnect
+	return nil
+}
+
+// connect opens the control connection and starts reading it, a previous
+// connection is closed and its receive goroutine has ended before
+func (c *Rtspclient) connect(ctx context.Context) error {
+	c.dropConn()
+	dialTimeout := c.dialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = time.Second * 5
+	}
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.host)
+	if err != nil {
+		return err
+	}
+	if c.secure {
+		conf := c.tlsConfig
+		if conf == nil {
+			conf = &tls.Config{InsecureSkipVerify: true}
+		}
+		tlsConn := tls.Client(conn, conf)
+		if deadline, ok := ctx.Deadline(); ok {
+			tlsConn.SetDeadline(deadline)
+		} else {
+			tlsConn.SetDeadline(time.Now().Add(dialTimeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	//Stop was called while dialing
+	if c.stopped {
+		conn.Close()
+		return ErrClosed
+	}
+	c.writeMtx.Lock()
+	c.conn = conn
+	c.writeMtx.Unlock()
+	c.stopFlag = false
+	c.quit = make(chan struct{})
+	c.keepAlive = false
+	c.paused = false
+	//credentials are negotiated again, the server or its nonces may have changed
+	c.auth = nil
+	if c.basicAuth && c.username != "" {
+		c.auth = &BasicAuthenticate{username: c.username, password: c.password}
+	}
+	c.pending = make(map[int]*pendingRequest)
+	c.cseq = 1
+	c.session = ""
+	c.updateLogFields(c.url, "")
+	c.recvDone = make(chan struct{})
+	go c.cycleRecv(conn, c.recvDone)
+	return nil
+}
+
+// Describe gets the session description, the tracks are those the client can decode
+func (c *Rtspclient) Describe(ctx context.Context) (*SessionDescription, error) {
+	_, err := c.requestFollow(ctx, func() Request { return MakeDescribe(c.url) }, c.handleDescribe)
+	if err != nil {
+		return nil, err
+	}
+	desc := &SessionDescription{Sdp: c.sdp}
+	c.statsMtx.Lock()
+	defer c.statsMtx.Unlock()
+	for i, media := range c.mediaChanel {
+		desc.Tracks = append(desc.Tracks, Track{
+			Index:       i,
+			Media:       media.media,
+			Codec:       media.codec,
+			PayloadType: media.pt,
+			ClockRate:   media.clockRate,
+			Control:     media.uri,
+			BackChannel: media.backchannel,
+			H264:        media.h264,
+			H265:        media.h265,
+			AAC:         media.aac,
+			Video:       media.video,
+			HasVideo:    media.hasVideo,
+		})
+	}
+	return desc, nil
+}
+
+func (c *Rtspclient) makeSetup(track int) Request {
+	req := MakeSetup(c.mediaChanel[track].uri)
+	req.Header.Set("Transport", NewTcpTransport(track*2, "PLAY").String())
+	return req
+}
+
+// Setup adds the track with index of Describe to the session,
+// the media is interleaved on the control connection
+func (c *Rtspclient) Setup(ctx context.Context, track int) error {
+	if track < 0 || track >= len(c.mediaChanel) {
+		return errors.New("no track " + strconv.Itoa(track) + ", call Describe first")
+	}
+	_, err := c.request(ctx, c.makeSetup(track), func(res Response) error {
+		return c.applySetup(track, res)
+	})
+	return err
+}
+
+// Play starts the tracks added by Setup, frames are passed to OnFrame
+func (c *Rtspclient) Play(ctx context.Context) error {
+	c.mtx.Lock()
+	session := c.session
+	c.mtx.Unlock()
+	if session == "" {
+		return errors.New("no track is setup")
+	}
+	_, err := c.request(ctx, c.makePlay(), c.handlePlay)
+	if err == nil && c.claimSupervision() {
+		go c.supervise()
+	}
+	return err
+}
+
+// Done is closed after Close, or when the connection is lost and is not
+// reconnected
+func (c *Rtspclient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close sends TEARDOWN and closes the connection
+func (c *Rtspclient) Close() error {
+	c.Stop()
+	return nil
+}
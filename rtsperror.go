@@ -0,0 +1,93 @@
+package rtsp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnauthorized         = errors.New("authentication failed")
+	ErrNotFound             = errors.New("stream not found")
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrUnsupportedTransport = errors.New("unsupported transport")
+	ErrTimeout              = errors.New("request timeout")
+	ErrClosed               = errors.New("rtsp client closed")
+	ErrTooManyRedirects     = errors.New("too many redirects")
+)
+
+// StatusError is returned for a request the server answered with a failure
+// status, errors.Is matches it against the sentinel errors above
+type StatusError struct {
+	StatusCode int
+	Reason     string
+	Method     string
+	URL        string
+	//Location of a 3xx response
+	Location string
+	//Retry-After of a 503 response, zero if absent
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return e.Method + " " + e.URL + ": " + strconv.Itoa(e.StatusCode) + " " + e.Reason
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == 401 || e.StatusCode == 403
+	case ErrNotFound:
+		return e.StatusCode == 404
+	case ErrSessionNotFound:
+		return e.StatusCode == 454
+	case ErrUnsupportedTransport:
+		return e.StatusCode == 461
+	}
+	return false
+}
+
+// Redirect reports a 3xx response with a Location to connect to
+func (e *StatusError) Redirect() bool {
+	return e.StatusCode >= 300 && e.StatusCode < 400 && e.Location != ""
+}
+
+func successStatus(res Response) bool {
+	return len(res.StatusCode) == 3 && res.StatusCode[0] == '2'
+}
+
+func statusError(req Request, res Response) error {
+	code, _ := strconv.Atoi(res.StatusCode)
+	e := &StatusError{
+		StatusCode: code,
+		Reason:     res.Reason,
+		Method:     req.Method,
+		URL:        req.Uri,
+		Location:   res.Header.Get("Location"),
+	}
+	if code == 503 {
+		e.RetryAfter = parseRetryAfter(res.Header.Get("Retry-After"))
+	}
+	return e
+}
+
+// Retry-After: delta-seconds or an rfc1123 date
+func parseRetryAfter(retryAfter string) time.Duration {
+	retryAfter = strings.TrimSpace(retryAfter)
+	if retryAfter == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := time.Parse(time.RFC1123, retryAfter); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
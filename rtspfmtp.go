@@ -0,0 +1,242 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// fmtpParams splits "key=value; key=value", the keys are case-insensitive
+func fmtpParams(fmtp string) map[string]string {
+	params := make(map[string]string)
+	for _, param := range strings.Split(fmtp, ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if kv[0] == "" {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if len(kv) == 2 {
+			params[key] = strings.TrimSpace(kv[1])
+		} else {
+			params[key] = ""
+		}
+	}
+	return params
+}
+
+// base64 nal units separated by commas
+func decodeParameterSets(sets string) ([][]byte, error) {
+	var nalus [][]byte
+	for _, set := range strings.Split(sets, ",") {
+		set = strings.TrimSpace(set)
+		if set == "" {
+			continue
+		}
+		nalu, err := base64.StdEncoding.DecodeString(set)
+		if err != nil {
+			//some servers drop the padding
+			if nalu, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(set, "=")); err != nil {
+				return nil, errors.New("wrong parameter set " + set)
+			}
+		}
+		if len(nalu) > 0 {
+			nalus = append(nalus, nalu)
+		}
+	}
+	return nalus, nil
+}
+
+// H264Params is the fmtp of rfc6184 8.1
+type H264Params struct {
+	ProfileIdc        uint8
+	ProfileIop        uint8 //constraint flags
+	LevelIdc          uint8
+	HasProfileLevelId bool
+	PacketizationMode int //0 single nal unit, 1 non-interleaved, 2 interleaved
+	//every set of sprop-parameter-sets in order, and the same split by nal unit type
+	ParameterSets [][]byte
+	SPS           [][]byte
+	PPS           [][]byte
+}
+
+func ParseH264Params(fmtp string) (H264Params, error) {
+	var h264 H264Params
+	params := fmtpParams(fmtp)
+	if id, ok := params["profile-level-id"]; ok {
+		b, err := hex.DecodeString(id)
+		if err != nil || len(b) != 3 {
+			return H264Params{}, errors.New("wrong profile-level-id " + id)
+		}
+		h264.ProfileIdc, h264.ProfileIop, h264.LevelIdc = b[0], b[1], b[2]
+		h264.HasProfileLevelId = true
+	}
+	if mode, ok := params["packetization-mode"]; ok {
+		var err error
+		if h264.PacketizationMode, err = strconv.Atoi(mode); err != nil {
+			return H264Params{}, errors.New("wrong packetization-mode " + mode)
+		}
+	}
+	if sets, ok := params["sprop-parameter-sets"]; ok {
+		nalus, err := decodeParameterSets(sets)
+		if err != nil {
+			return H264Params{}, err
+		}
+		h264.ParameterSets = nalus
+		for _, nalu := range nalus {
+			switch nalu[0] & 0x1F {
+			case 7:
+				h264.SPS = append(h264.SPS, nalu)
+			case 8:
+				h264.PPS = append(h264.PPS, nalu)
+			}
+		}
+	}
+	return h264, nil
+}
+
+// H265Params is the fmtp of rfc7798 7.1
+type H265Params struct {
+	ProfileSpace  int
+	ProfileId     int
+	TierFlag      int
+	LevelId       int
+	VPS           [][]byte
+	SPS           [][]byte
+	PPS           [][]byte
+	SEI           [][]byte
+	MaxDonDiff    int //sprop-max-don-diff, above zero the nal units may come out of decoding order
+	HasMaxDonDiff bool
+}
+
+func ParseH265Params(fmtp string) (H265Params, error) {
+	var h265 H265Params
+	params := fmtpParams(fmtp)
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"profile-space", &h265.ProfileSpace},
+		{"profile-id", &h265.ProfileId},
+		{"tier-flag", &h265.TierFlag},
+		{"level-id", &h265.LevelId},
+		{"sprop-max-don-diff", &h265.MaxDonDiff},
+	}
+	for _, param := range ints {
+		value, ok := params[param.name]
+		if !ok {
+			continue
+		}
+		var err error
+		if *param.value, err = strconv.Atoi(value); err != nil {
+			return H265Params{}, errors.New("wrong " + param.name + " " + value)
+		}
+	}
+	_, h265.HasMaxDonDiff = params["sprop-max-don-diff"]
+	sets := []struct {
+		name  string
+		nalus *[][]byte
+	}{
+		{"sprop-vps", &h265.VPS},
+		{"sprop-sps", &h265.SPS},
+		{"sprop-pps", &h265.PPS},
+		{"sprop-sei", &h265.SEI},
+	}
+	for _, set := range sets {
+		value, ok := params[set.name]
+		if !ok {
+			continue
+		}
+		nalus, err := decodeParameterSets(value)
+		if err != nil {
+			return H265Params{}, err
+		}
+		*set.nalus = nalus
+	}
+	return h265, nil
+}
+
+// AACParams is the fmtp of mpeg4-generic, rfc3640 4.1
+type AACParams struct {
+	StreamType       int
+	ProfileLevelId   int
+	Mode             string //AAC-hbr, AAC-lbr...
+	Config           []byte //AudioSpecificConfig
+	ObjectType       int    //of Config, 2 is AAC LC
+	SampleRate       int    //of Config
+	Channels         int    //of Config
+	SizeLength       int
+	IndexLength      int
+	IndexDeltaLength int
+	CTSDeltaLength   int
+	DTSDeltaLength   int
+	ConstantSize     int
+}
+
+func ParseAACParams(fmtp string) (AACParams, error) {
+	var aac AACParams
+	params := fmtpParams(fmtp)
+	aac.Mode = params["mode"]
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"streamtype", &aac.StreamType},
+		{"profile-level-id", &aac.ProfileLevelId},
+		{"sizelength", &aac.SizeLength},
+		{"indexlength", &aac.IndexLength},
+		{"indexdeltalength", &aac.IndexDeltaLength},
+		{"ctsdeltalength", &aac.CTSDeltaLength},
+		{"dtsdeltalength", &aac.DTSDeltaLength},
+		{"constantsize", &aac.ConstantSize},
+	}
+	for _, param := range ints {
+		value, ok := params[param.name]
+		if !ok || value == "" {
+			continue
+		}
+		var err error
+		if *param.value, err = strconv.Atoi(value); err != nil {
+			return AACParams{}, errors.New("wrong " + param.name + " " + value)
+		}
+	}
+	if config, ok := params["config"]; ok && config != "" {
+		var err error
+		if aac.Config, err = hex.DecodeString(config); err != nil {
+			return AACParams{}, errors.New("wrong config " + config)
+		}
+		if aac.SampleRate, aac.Channels, err = parseAacConfig(aac.Config); err != nil {
+			return AACParams{}, err
+		}
+		aac.ObjectType = int(aac.Config[0] >> 3)
+	}
+	return aac, nil
+}
+
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// AudioSpecificConfig of iso14496-3 1.6.2.1, only the sample rate and the channels
+func parseAacConfig(config []byte) (sampleRate int, channels int, err error) {
+	if len(config) < 2 {
+		return 0, 0, errors.New("aac config is too short")
+	}
+	objectType := config[0] >> 3
+	index := (config[0]&0x07)<<1 | config[1]>>7
+	if objectType == 31 {
+		return 0, 0, errors.New("aac escaped object type is not supported")
+	}
+	if index == 15 {
+		if len(config) < 5 {
+			return 0, 0, errors.New("aac config is too short")
+		}
+		sampleRate = int(config[1]&0x7f)<<17 | int(config[2])<<9 | int(config[3])<<1 | int(config[4]>>7)
+		channels = int(config[4] >> 3 & 0x0f)
+	} else if int(index) < len(aacSampleRates) {
+		sampleRate = aacSampleRates[index]
+		channels = int(config[1] >> 3 & 0x0f)
+	} else {
+		return 0, 0, errors.New("aac config has a wrong sample rate index")
+	}
+	return sampleRate, channels, nil
+}
@@ -0,0 +1,148 @@
+package rtsp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const (
+	hikSps     = "Z00AKp2oHgCJ+WbgICAoAAADAAgAAAMBlCA="
+	hikPps     = "aO48gA=="
+	hikH265Vps = "QAEMAf//AWAAAAMAkAAAAwAAAwB4mZgJ"
+	hikH265Sps = "QgEBAWAAAAMAkAAAAwAAAwB4oAPAgBDllmZpJMrgEAAAAwAQAAADAZQg"
+	hikH265Pps = "RAHA8vA8kAA="
+)
+
+func TestParseH264Params(t *testing.T) {
+	sps, pps := decodeSprop(t, hikSps), decodeSprop(t, hikPps)
+	dahuaSps := decodeSprop(t, "Z2QAKKwbGoB4AiflwFuAgICgAAB9AAAOph0MAHz4AAjDeu8uNDAB8+AAIw3rvLhQAA==")
+	dahuaPps := decodeSprop(t, "aO44sA==")
+	tests := []struct {
+		name string
+		fmtp string
+		want H264Params
+		err  bool
+	}{
+		{"hikvision", "packetization-mode=1;profile-level-id=4D002A;sprop-parameter-sets=" + hikSps + "," + hikPps,
+			H264Params{ProfileIdc: 0x4D, LevelIdc: 0x2A, HasProfileLevelId: true, PacketizationMode: 1,
+				ParameterSets: [][]byte{sps, pps}, SPS: [][]byte{sps}, PPS: [][]byte{pps}}, false},
+		{"dahua", "packetization-mode=1;profile-level-id=640028;sprop-parameter-sets=Z2QAKKwbGoB4AiflwFuAgICgAAB9AAAOph0MAHz4AAjDeu8uNDAB8+AAIw3rvLhQAA==,aO44sA==",
+			H264Params{ProfileIdc: 0x64, LevelIdc: 0x28, HasProfileLevelId: true, PacketizationMode: 1,
+				ParameterSets: [][]byte{dahuaSps, dahuaPps}, SPS: [][]byte{dahuaSps}, PPS: [][]byte{dahuaPps}}, false},
+		//spaces, upper case keys and no base64 padding
+		{"loose", " Packetization-Mode=1; profile-level-id=42e01f; sprop-parameter-sets=Z00AKp2oHgCJ+WbgICAoAAADAAgAAAMBlCA,aO48gA",
+			H264Params{ProfileIdc: 0x42, ProfileIop: 0xE0, LevelIdc: 0x1F, HasProfileLevelId: true, PacketizationMode: 1,
+				ParameterSets: [][]byte{sps, pps}, SPS: [][]byte{sps}, PPS: [][]byte{pps}}, false},
+		{"empty", "", H264Params{}, false},
+		{"bad base64", "packetization-mode=1;sprop-parameter-sets=Z00AKp2o!!,aO48gA==", H264Params{}, true},
+		{"short profile-level-id", "profile-level-id=4D00", H264Params{}, true},
+		{"hex profile-level-id", "profile-level-id=4D00ZZ", H264Params{}, true},
+		{"bad packetization-mode", "packetization-mode=one", H264Params{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseH264Params(tt.fmtp)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseH265Params(t *testing.T) {
+	vps, sps, pps := decodeSprop(t, hikH265Vps), decodeSprop(t, hikH265Sps), decodeSprop(t, hikH265Pps)
+	tests := []struct {
+		name string
+		fmtp string
+		want H265Params
+		err  bool
+	}{
+		{"hikvision", "profile-space=0;profile-id=1;tier-flag=0;level-id=120;interop-constraints=900000000000;sprop-vps=" + hikH265Vps +
+			";sprop-sps=" + hikH265Sps + ";sprop-pps=" + hikH265Pps,
+			H265Params{ProfileId: 1, LevelId: 120, VPS: [][]byte{vps}, SPS: [][]byte{sps}, PPS: [][]byte{pps}}, false},
+		{"sets only", "sprop-vps=" + hikH265Vps + "; sprop-sps=" + hikH265Sps + "; sprop-pps=" + hikH265Pps,
+			H265Params{VPS: [][]byte{vps}, SPS: [][]byte{sps}, PPS: [][]byte{pps}}, false},
+		{"max don diff", "sprop-max-don-diff=2;level-id=93", H265Params{LevelId: 93, MaxDonDiff: 2, HasMaxDonDiff: true}, false},
+		{"bad base64", "sprop-vps=" + hikH265Vps + ";sprop-sps=QgEB*AWAA", H265Params{}, true},
+		{"bad level-id", "level-id=high", H265Params{}, true},
+		{"bad max don diff", "sprop-max-don-diff=", H265Params{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseH265Params(tt.fmtp)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseAACParams(t *testing.T) {
+	tests := []struct {
+		name string
+		fmtp string
+		want AACParams
+		err  bool
+	}{
+		{"16k mono", "streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1408",
+			AACParams{StreamType: 5, ProfileLevelId: 1, Mode: "AAC-hbr", Config: []byte{0x14, 0x08}, ObjectType: 2, SampleRate: 16000, Channels: 1,
+				SizeLength: 13, IndexLength: 3, IndexDeltaLength: 3}, false},
+		{"live555 stereo", "streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1210",
+			AACParams{StreamType: 5, ProfileLevelId: 1, Mode: "AAC-hbr", Config: []byte{0x12, 0x10}, ObjectType: 2, SampleRate: 44100, Channels: 2,
+				SizeLength: 13, IndexLength: 3, IndexDeltaLength: 3}, false},
+		{"lbr", "streamtype=5; mode=AAC-lbr; sizelength=6; indexlength=2; indexdeltalength=2; config=1588",
+			AACParams{StreamType: 5, Mode: "AAC-lbr", Config: []byte{0x15, 0x88}, ObjectType: 2, SampleRate: 8000, Channels: 1,
+				SizeLength: 6, IndexLength: 2, IndexDeltaLength: 2}, false},
+		//empty values are left out by some cameras
+		{"empty values", "streamtype=5;profile-level-id=;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=",
+			AACParams{StreamType: 5, Mode: "AAC-hbr", SizeLength: 13, IndexLength: 3, IndexDeltaLength: 3}, false},
+		{"bad sizelength", "mode=AAC-hbr;sizelength=thirteen", AACParams{}, true},
+		{"odd config hex", "mode=AAC-hbr;config=140", AACParams{}, true},
+		{"short config", "mode=AAC-hbr;config=14", AACParams{}, true},
+		{"bad config hex", "mode=AAC-hbr;config=14G8", AACParams{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAACParams(tt.fmtp)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseAacConfig(t *testing.T) {
+	tests := []struct {
+		config     []byte
+		sampleRate int
+		channels   int
+		err        bool
+	}{
+		{[]byte{0x14, 0x08}, 16000, 1, false},
+		{[]byte{0x11, 0x90}, 48000, 2, false},
+		{[]byte{0x15, 0x88}, 8000, 1, false},
+		//sbr, the core of HE-AAC
+		{[]byte{0x2B, 0x92, 0x08, 0x00}, 22050, 2, false},
+		//an explicit sample rate of 48000 after the escape index 15
+		{[]byte{0x17, 0x80, 0x5D, 0xC0, 0x10}, 48000, 2, false},
+		{[]byte{0x17, 0x80, 0x5D, 0xC0}, 0, 0, true},
+		{[]byte{0x16, 0x80}, 0, 0, true},
+		{[]byte{0xF8, 0x00}, 0, 0, true},
+		{[]byte{0x14}, 0, 0, true},
+		{nil, 0, 0, true},
+	}
+	for _, tt := range tests {
+		sampleRate, channels, err := parseAacConfig(tt.config)
+		if (err != nil) != tt.err || sampleRate != tt.sampleRate || channels != tt.channels {
+			t.Errorf("% x: got %d %d %v", tt.config, sampleRate, channels, err)
+		}
+	}
+}
+
+func TestDecodeParameterSets(t *testing.T) {
+	nalus, err := decodeParameterSets(" " + hikSps + " ,, " + hikPps + ",")
+	if err != nil || len(nalus) != 2 || !bytes.Equal(nalus[0], decodeSprop(t, hikSps)) {
+		t.Errorf("got % x %v", nalus, err)
+	}
+}
@@ -0,0 +1,209 @@
+package rtsp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy decides what happens to a frame when the queue of Frames is full
+type DropPolicy int
+
+const (
+	//the oldest queued frame is dropped to make room
+	DropOldest DropPolicy = iota
+	//non-key frames are dropped until the next key frame, a key frame replaces the oldest one
+	DropNonKey
+	//the receive loop waits for the consumer, the server is slowed down by tcp flow control
+	Block
+)
+
+const defaultQueueDepth = 64
+
+// frameBuffer owns the data of one frame, a copy of the Frame shares it
+type frameBuffer struct {
+	data     []byte
+	released int32 //the data went back to the pool
+}
+
+var framePool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+// Release returns the data of a frame received from Frames to a pool,
+// Data must not be used afterwards. Frames passed to OnFrame are not pooled.
+// Releasing a frame or a copy of it again does nothing
+func (f Frame) Release() {
+	if f.buf == nil || !atomic.CompareAndSwapInt32(&f.buf.released, 0, 1) {
+		return
+	}
+	data := f.buf.data[:0]
+	f.buf.data = nil
+	framePool.Put(&data)
+}
+
+// owned copies Data into a pooled buffer, the decoders reuse theirs for the next frame
+func (f Frame) owned() Frame {
+	data := framePool.Get().(*[]byte)
+	f.buf = &frameBuffer{data: append((*data)[:0], f.Data...)}
+	f.Data = f.buf.data
+	return f
+}
+
+type frameQueue struct {
+	mtx     sync.Mutex
+	ch      chan Frame
+	policy  DropPolicy
+	closed  bool
+	waitKey bool //a video frame was dropped, the following ones reference it
+	dropped uint64
+}
+
+func newFrameQueue(depth int, policy DropPolicy) *frameQueue {
+	if depth <= 0 {
+		depth = defaultQueueDepth
+	}
+	return &frameQueue{ch: make(chan Frame, depth), policy: policy}
+}
+
+// push runs on the receive goroutine, done ends a blocked push when the client stops
+func (q *frameQueue) push(frame Frame, done chan struct{}) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if q.closed {
+		return
+	}
+	video := isVideo(frame)
+	if q.policy == DropNonKey && video && q.waitKey {
+		if !frame.IsKey {
+			q.drop(frame)
+			return
+		}
+		q.waitKey = false
+	}
+	frame = frame.owned()
+	if q.policy == Block {
+		select {
+		case q.ch <- frame:
+		case <-done:
+			frame.Release()
+		}
+		return
+	}
+	for {
+		select {
+		case q.ch <- frame:
+			return
+		default:
+		}
+		if q.policy == DropNonKey && !frame.IsKey {
+			if video {
+				q.waitKey = true
+			}
+			q.drop(frame)
+			return
+		}
+		select {
+		case oldest := <-q.ch:
+			q.drop(oldest)
+			if q.policy == DropNonKey && isVideo(oldest) {
+				//a key frame of the queue or this one starts over
+				q.waitKey = !q.dropReferencing() && !(video && frame.IsKey)
+			}
+		default:
+		}
+	}
+}
+
+func isVideo(frame Frame) bool {
+	return frame.Cid == H264 || frame.Cid == H265
+}
+
+// dropReferencing drops the queued video frames up to the next key frame, they
+// reference a dropped one. It tells if a key frame was queued
+func (q *frameQueue) dropReferencing() bool {
+	queued := make([]Frame, 0, len(q.ch))
+	for n := len(q.ch); n > 0; n-- {
+		select {
+		case frame := <-q.ch:
+			queued = append(queued, frame)
+		default:
+		}
+	}
+	key := false
+	for _, frame := range queued {
+		if !key && isVideo(frame) {
+			if !frame.IsKey {
+				q.drop(frame)
+				continue
+			}
+			key = true
+		}
+		//only push sends and it holds mtx, there is room for what was taken
+		q.ch <- frame
+	}
+	return key
+}
+
+func (q *frameQueue) drop(frame Frame) {
+	atomic.AddUint64(&q.dropped, 1)
+	frame.Release()
+}
+
+// close is called after Done is closed, frames left in the channel can still be read
+func (q *frameQueue) close() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
+
+// SetFrameQueue configures Frames, it must be called before Frames
+func (c *Rtspclient) SetFrameQueue(depth int, policy DropPolicy) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.queueDepth = depth
+	c.dropPolicy = policy
+}
+
+// Frames delivers the frames through a queue, so a slow consumer does not stall
+// the connection unless the policy is Block. Every frame owns its Data and should
+// be released when done with. The channel is closed after Done
+func (c *Rtspclient) Frames() <-chan Frame {
+	c.mtx.Lock()
+	if c.frames == nil {
+		c.frames = newFrameQueue(c.queueDepth, c.dropPolicy)
+		if c.finished {
+			c.frames.close()
+		}
+	}
+	ch := c.frames.ch
+	c.mtx.Unlock()
+	return ch
+}
+
+// DroppedFrames counts the frames dropped because the queue of Frames was full
+func (c *Rtspclient) DroppedFrames() uint64 {
+	c.mtx.Lock()
+	q := c.frames
+	c.mtx.Unlock()
+	if q == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&q.dropped)
+}
+
+func (c *Rtspclient) deliver(frame Frame) {
+	if c.OnFrame != nil {
+		c.OnFrame(frame)
+	}
+	c.mtx.Lock()
+	q := c.frames
+	c.mtx.Unlock()
+	if q != nil {
+		q.push(frame, c.done)
+	}
+}
@@ -0,0 +1,135 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+)
+
+func queuedFrames(q *frameQueue) []Frame {
+	var frames []Frame
+	for len(q.ch) > 0 {
+		frames = append(frames, <-q.ch)
+	}
+	return frames
+}
+
+// queueNames reads the queue, a frame is named by its first data byte
+func queueNames(q *frameQueue) string {
+	var names string
+	for _, frame := range queuedFrames(q) {
+		names += string(frame.Data[0])
+		frame.Release()
+	}
+	return names
+}
+
+func keyFrame(name byte) Frame {
+	return Frame{Cid: H264, Data: []byte{name}, IsKey: true}
+}
+
+func refFrame(name byte) Frame {
+	return Frame{Cid: H264, Data: []byte{name}}
+}
+
+func audioFrame(name byte) Frame {
+	return Frame{Cid: AAC, Data: []byte{name}, IsKey: true}
+}
+
+func TestFrameQueueDropPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  DropPolicy
+		depth   int
+		frames  []Frame
+		queued  string
+		dropped uint64
+	}{
+		{"drop oldest", DropOldest, 3, []Frame{keyFrame('K'), refFrame('a'), refFrame('b'), refFrame('c'), audioFrame('1')}, "bc1", 2},
+		{"drop non key frames", DropNonKey, 3, []Frame{keyFrame('K'), refFrame('a'), refFrame('b'), refFrame('c'), refFrame('d')}, "Kab", 2},
+		//c was dropped and waits for L, L replaces K and so drops a and b
+		{"wait for a key frame", DropNonKey, 3, []Frame{keyFrame('K'), refFrame('a'), refFrame('b'), refFrame('c'), keyFrame('L'), refFrame('d')}, "Ld", 4},
+		{"key frame replaces the oldest", DropNonKey, 2, []Frame{keyFrame('K'), keyFrame('L'), keyFrame('M')}, "LM", 1},
+		//the queued a and b reference the dropped K
+		{"dropping a key frame drops its references", DropNonKey, 3, []Frame{keyFrame('K'), refFrame('a'), refFrame('b'), audioFrame('1')}, "1", 3},
+		{"references are dropped up to a queued key frame", DropNonKey, 4,
+			[]Frame{keyFrame('K'), refFrame('a'), audioFrame('1'), keyFrame('L'), audioFrame('2'), refFrame('b')}, "1L2b", 2},
+		//the dropped K leaves a reference of a queued b behind, c must wait for a key frame
+		{"wait after dropping the oldest", DropNonKey, 2,
+			[]Frame{keyFrame('K'), refFrame('a'), audioFrame('1'), refFrame('c'), keyFrame('L')}, "1L", 3},
+		{"audio is not waited for", DropNonKey, 2, []Frame{keyFrame('K'), refFrame('a'), refFrame('b'), audioFrame('1'), audioFrame('2'), refFrame('c')}, "12", 4},
+	}
+	for _, tt := range tests {
+		q := newFrameQueue(tt.depth, tt.policy)
+		for _, frame := range tt.frames {
+			q.push(frame, nil)
+		}
+		if queued := queueNames(q); queued != tt.queued || q.dropped != tt.dropped {
+			t.Errorf("%s: queued %q dropped %d, want %q %d", tt.name, queued, q.dropped, tt.queued, tt.dropped)
+		}
+	}
+}
+
+func TestFrameQueueBlock(t *testing.T) {
+	q := newFrameQueue(1, Block)
+	done := make(chan struct{})
+	q.push(keyFrame('K'), done)
+	pushed := make(chan struct{})
+	go func() {
+		q.push(refFrame('a'), done)
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if frame := <-q.ch; frame.Data[0] != 'K' {
+		t.Errorf("got %c", frame.Data[0])
+	}
+	<-pushed
+	if frame := <-q.ch; frame.Data[0] != 'a' || q.dropped != 0 {
+		t.Errorf("got %c, dropped %d", frame.Data[0], q.dropped)
+	}
+
+	//a stopped client ends a blocked push
+	q.push(keyFrame('L'), done)
+	pushed = make(chan struct{})
+	go func() {
+		q.push(refFrame('b'), done)
+		close(pushed)
+	}()
+	close(done)
+	<-pushed
+	q.push(refFrame('c'), done)
+	if queued := queueNames(q); queued != "L" {
+		t.Errorf("queued %q", queued)
+	}
+}
+
+func TestFrameQueueOwnsData(t *testing.T) {
+	q := newFrameQueue(2, DropOldest)
+	data := []byte{1, 2, 3}
+	q.push(Frame{Cid: H264, Data: data, IsKey: true}, nil)
+	data[0] = 9
+	frame := <-q.ch
+	if frame.Data[0] != 1 {
+		t.Error("the queued frame shares the data of the decoder")
+	}
+}
+
+func TestFrameReleaseTwice(t *testing.T) {
+	frame := Frame{Data: []byte{1, 2, 3}}.owned()
+	copied := frame
+	frame.Release()
+	frame.Release()
+	copied.Release()
+	//one buffer went back to the pool, two frames must not share it
+	first := Frame{Data: []byte{4, 5, 6}}.owned()
+	second := Frame{Data: []byte{7, 8, 9}}.owned()
+	if &first.Data[0] == &second.Data[0] || first.Data[0] != 4 {
+		t.Errorf("frames share a buffer: % x % x", first.Data, second.Data)
+	}
+	first.Release()
+	second.Release()
+	Frame{Data: []byte{1}}.Release()
+}
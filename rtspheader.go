@@ -0,0 +1,674 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// spellings which textproto.CanonicalMIMEHeaderKey does not produce
+var canonicalHeaders = map[string]string{
+	"cseq":                   "CSeq",
+	"www-authenticate":       "WWW-Authenticate",
+	"rtp-info":               "RTP-Info",
+	"mtag":                   "MTag",
+	"ssrc":                   "SSRC",
+	"accept-credentials":     "Accept-Credentials",
+	"connection-credentials": "Connection-Credentials",
+}
+
+func CanonicalHeaderName(name string) string {
+	name = strings.TrimSpace(name)
+	if canonical, ok := canonicalHeaders[strings.ToLower(name)]; ok {
+		return canonical
+	}
+	return textproto.CanonicalMIMEHeaderKey(name)
+}
+
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// Header keeps the fields of a message in the order they were added,
+// names are compared case-insensitively and a name may repeat
+type Header struct {
+	fields []HeaderField
+}
+
+// HeaderFromMap helps code written against the old map[string]string headers
+func HeaderFromMap(m map[string]string) Header {
+	var h Header
+	for name, value := range m {
+		h.Add(name, value)
+	}
+	return h
+}
+
+// Map returns the first value of every field, like the old HeaderFileds map
+func (h Header) Map() map[string]string {
+	m := make(map[string]string, len(h.fields))
+	for _, field := range h.fields {
+		if _, ok := m[field.Name]; !ok {
+			m[field.Name] = field.Value
+		}
+	}
+	return m
+}
+
+func (h Header) Get(name string) string {
+	name = CanonicalHeaderName(name)
+	for _, field := range h.fields {
+		if field.Name == name {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+func (h Header) Has(name string) bool {
+	name = CanonicalHeaderName(name)
+	for _, field := range h.fields {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Header) Values(name string) []string {
+	name = CanonicalHeaderName(name)
+	var values []string
+	for _, field := range h.fields {
+		if field.Name == name {
+			values = append(values, field.Value)
+		}
+	}
+	return values
+}
+
+func (h *Header) Add(name string, value string) {
+	h.fields = append(h.fields, HeaderField{Name: CanonicalHeaderName(name), Value: value})
+}
+
+// Set replaces the first field with name in place and removes the others
+func (h *Header) Set(name string, value string) {
+	name = CanonicalHeaderName(name)
+	for i := range h.fields {
+		if h.fields[i].Name == name {
+			h.fields[i].Value = value
+			h.removeFrom(name, i+1)
+			return
+		}
+	}
+	h.Add(name, value)
+}
+
+func (h *Header) Del(name string) {
+	h.removeFrom(CanonicalHeaderName(name), 0)
+}
+
+func (h *Header) removeFrom(name string, start int) {
+	fields := h.fields[:start]
+	for _, field := range h.fields[start:] {
+		if field.Name != name {
+			fields = append(fields, field)
+		}
+	}
+	h.fields = fields
+}
+
+func (h Header) Fields() []HeaderField {
+	return append([]HeaderField(nil), h.fields...)
+}
+
+func (h Header) Len() int {
+	return len(h.fields)
+}
+
+// Clone is needed before changing a header shared by copied messages
+func (h Header) Clone() Header {
+	return Header{fields: h.Fields()}
+}
+
+func (h Header) write(sb *strings.Builder) {
+	for _, field := range h.fields {
+		sb.WriteString(field.Name)
+		sb.WriteString(": ")
+		sb.WriteString(field.Value)
+		sb.WriteString("\r\n")
+	}
+}
+
+// Session: 12345678;timeout=60
+type Session struct {
+	ID      string
+	Timeout int //seconds, zero if absent
+}
+
+func ParseSession(session string) (Session, error) {
+	var s Session
+	params := strings.Split(session, ";")
+	s.ID = strings.TrimSpace(params[0])
+	if s.ID == "" {
+		return s, errors.New("empty session id")
+	}
+	for _, param := range params[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], "timeout") {
+			timeout, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+			if err != nil {
+				return s, errors.New("wrong session timeout " + kv[1])
+			}
+			s.Timeout = timeout
+		}
+	}
+	return s, nil
+}
+
+func (s Session) String() string {
+	if s.Timeout > 0 {
+		return s.ID + ";timeout=" + strconv.Itoa(s.Timeout)
+	}
+	return s.ID
+}
+
+// Methods is the value of Public or Allow: OPTIONS, DESCRIBE, SETUP
+type Methods []string
+
+func ParseMethods(methods string) Methods {
+	var result Methods
+	for _, method := range strings.Split(methods, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			result = append(result, strings.ToUpper(method))
+		}
+	}
+	return result
+}
+
+func (m Methods) Has(method string) bool {
+	for _, elem := range m {
+		if strings.EqualFold(elem, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Methods) String() string {
+	return strings.Join(m, ", ")
+}
+
+// FeatureTags is the value of Require, Proxy-Require, Supported or Unsupported,
+// tags are compared case-sensitively
+type FeatureTags []string
+
+func ParseFeatureTags(tags string) FeatureTags {
+	var result FeatureTags
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func (f FeatureTags) Has(tag string) bool {
+	for _, elem := range f {
+		if elem == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (f FeatureTags) String() string {
+	return strings.Join(f, ", ")
+}
+
+// ContentBase is the base url of a DESCRIBE response, rfc2326 C.1.1
+// 1. The RTSP Content-Base field
+// 2. The RTSP Content-Location field
+// 3. The RTSP request URL
+func (res *Response) ContentBase(requestUrl string) string {
+	if base := res.Header.Get("Content-Base"); base != "" {
+		return base
+	}
+	if location := res.Header.Get("Content-Location"); location != "" {
+		return location
+	}
+	return requestUrl
+}
+
+const (
+	RangeNpt         = "npt"
+	RangeClock       = "clock"
+	RangeSmpte       = "smpte"
+	RangeSmpte30Drop = "smpte-30-drop"
+	RangeSmpte25     = "smpte-25"
+)
+
+// SmpteTime: 10:07:33:05.01, frames and subframes are optional
+type SmpteTime struct {
+	Hours     int
+	Minutes   int
+	Seconds   int
+	Frames    int
+	Subframes int
+}
+
+func parseSmpte(smpte string) (SmpteTime, error) {
+	var t SmpteTime
+	elems := strings.Split(smpte, ":")
+	if len(elems) != 3 && len(elems) != 4 {
+		return t, errors.New("wrong smpte time " + smpte)
+	}
+	if len(elems) == 4 {
+		frames := strings.SplitN(elems[3], ".", 2)
+		elems = append(elems[:3], frames...)
+	}
+	values := []*int{&t.Hours, &t.Minutes, &t.Seconds, &t.Frames, &t.Subframes}
+	for i, elem := range elems {
+		v, err := strconv.Atoi(elem)
+		if err != nil {
+			return t, errors.New("wrong smpte time " + smpte)
+		}
+		*values[i] = v
+	}
+	return t, nil
+}
+
+func (t SmpteTime) String() string {
+	smpte := fmt.Sprintf("%d:%02d:%02d", t.Hours, t.Minutes, t.Seconds)
+	if t.Frames > 0 || t.Subframes > 0 {
+		smpte += fmt.Sprintf(":%02d", t.Frames)
+	}
+	if t.Subframes > 0 {
+		smpte += fmt.Sprintf(".%02d", t.Subframes)
+	}
+	return smpte
+}
+
+// Range: npt=10.5-20, smpte=10:07:00-10:07:33:05.01 or clock=19961108T142300Z-19961108T143520Z
+type Range struct {
+	Unit        string
+	Start       time.Duration //npt
+	End         time.Duration //npt, negative means open-ended
+	Now         bool          //npt=now-
+	StartTime   time.Time     //clock
+	EndTime     time.Time     //clock, zero means open-ended
+	SmpteStart  SmpteTime     //smpte, smpte-30-drop, smpte-25
+	SmpteEnd    SmpteTime
+	HasSmpteEnd bool
+}
+
+// NptRange with a negative end plays to the end of the stream
+func NptRange(start, end time.Duration) Range {
+	return Range{Unit: RangeNpt, Start: start, End: end}
+}
+
+// ClockRange with a zero end plays to the end of the recording
+func ClockRange(start, end time.Time) Range {
+	return Range{Unit: RangeClock, StartTime: start, EndTime: end}
+}
+
+func formatNpt(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// npt-time = "now" | npt-sec | npt-hhmmss
+func parseNpt(npt string) (time.Duration, error) {
+	var seconds float64
+	elems := strings.Split(npt, ":")
+	if len(elems) != 1 && len(elems) != 3 {
+		return 0, errors.New("wrong npt time " + npt)
+	}
+	for _, elem := range elems {
+		v, err := strconv.ParseFloat(elem, 64)
+		if err != nil {
+			return 0, errors.New("wrong npt time " + npt)
+		}
+		seconds = seconds*60 + v
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func parseClock(clock string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405.999999999Z", "20060102T150405Z"} {
+		if t, err := time.Parse(layout, clock); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("wrong clock time " + clock)
+}
+
+func (r Range) String() string {
+	switch r.Unit {
+	case RangeNpt:
+		var rng string
+		if r.Now {
+			rng = "npt=now-"
+		} else {
+			rng = "npt=" + formatNpt(r.Start) + "-"
+		}
+		if r.End >= 0 && !r.Now {
+			rng += formatNpt(r.End)
+		}
+		return rng
+	case RangeClock:
+		rng := "clock=" + r.StartTime.UTC().Format(onvifClockFormat) + "-"
+		if !r.EndTime.IsZero() {
+			rng += r.EndTime.UTC().Format(onvifClockFormat)
+		}
+		return rng
+	case RangeSmpte, RangeSmpte30Drop, RangeSmpte25:
+		rng := r.Unit + "=" + r.SmpteStart.String() + "-"
+		if r.HasSmpteEnd {
+			rng += r.SmpteEnd.String()
+		}
+		return rng
+	}
+	return ""
+}
+
+func ParseRange(rangestr string) (Range, error) {
+	var r Range
+	//drop ;time= parameter
+	rangestr = strings.TrimSpace(strings.SplitN(rangestr, ";", 2)[0])
+	kv := strings.SplitN(rangestr, "=", 2)
+	if len(kv) != 2 {
+		return r, errors.New("wrong range " + rangestr)
+	}
+	r.Unit = strings.ToLower(strings.TrimSpace(kv[0]))
+	startend := strings.SplitN(kv[1], "-", 2)
+	if len(startend) != 2 {
+		return r, errors.New("wrong range " + rangestr)
+	}
+	start, end := strings.TrimSpace(startend[0]), strings.TrimSpace(startend[1])
+	var err error
+	switch r.Unit {
+	case RangeNpt:
+		r.End = -1
+		if start == "now" {
+			r.Now = true
+		} else if start != "" {
+			if r.Start, err = parseNpt(start); err != nil {
+				return r, err
+			}
+		}
+		if end != "" {
+			if r.End, err = parseNpt(end); err != nil {
+				return r, err
+			}
+		}
+	case RangeClock:
+		if r.StartTime, err = parseClock(start); err != nil {
+			return r, err
+		}
+		if end != "" {
+			if r.EndTime, err = parseClock(end); err != nil {
+				return r, err
+			}
+		}
+	case RangeSmpte, RangeSmpte30Drop, RangeSmpte25:
+		if r.SmpteStart, err = parseSmpte(start); err != nil {
+			return r, err
+		}
+		if end != "" {
+			if r.SmpteEnd, err = parseSmpte(end); err != nil {
+				return r, err
+			}
+			r.HasSmpteEnd = true
+		}
+	default:
+		return r, errors.New("unsupport range unit " + r.Unit)
+	}
+	return r, nil
+}
+
+// RTP-Info: url=rtsp://foo.com/bar.avi/streamid=0;seq=45102;rtptime=12345
+// rtsp 2.0 puts the parameters after the ssrc of the stream
+// RTP-Info: url="rtsp://foo.com/bar.avi/streamid=0" ssrc=0A13C760:seq=45102;rtptime=12345
+type RtpInfo struct {
+	Url        string
+	Ssrc       uint32
+	HasSsrc    bool
+	Seq        uint16
+	HasSeq     bool
+	RtpTime    uint32
+	HasRtpTime bool
+}
+
+func (info RtpInfo) String() string {
+	var str, sep string
+	if info.HasSsrc {
+		str, sep = "url=\""+info.Url+"\""+fmt.Sprintf(" ssrc=%08X", info.Ssrc), ":"
+	} else {
+		str, sep = "url="+info.Url, ";"
+	}
+	var params []string
+	if info.HasSeq {
+		params = append(params, fmt.Sprintf("seq=%d", info.Seq))
+	}
+	if info.HasRtpTime {
+		params = append(params, fmt.Sprintf("rtptime=%d", info.RtpTime))
+	}
+	if len(params) == 0 {
+		return str
+	}
+	return str + sep + strings.Join(params, ";")
+}
+
+func (info *RtpInfo) parseParams(params string) error {
+	for _, param := range strings.Split(params, ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "seq":
+			seq, err := strconv.ParseUint(kv[1], 10, 16)
+			if err != nil {
+				return errors.New("wrong rtp-info seq " + kv[1])
+			}
+			info.Seq = uint16(seq)
+			info.HasSeq = true
+		case "rtptime":
+			rtptime, err := strconv.ParseUint(kv[1], 10, 32)
+			if err != nil {
+				return errors.New("wrong rtp-info rtptime " + kv[1])
+			}
+			info.RtpTime = uint32(rtptime)
+			info.HasRtpTime = true
+		}
+	}
+	return nil
+}
+
+// the url is quoted in rtsp 2.0 and may be followed by several ssrc, one RtpInfo for each
+func parseRtpInfoStream(stream string) ([]RtpInfo, error) {
+	stream = strings.TrimSpace(stream)
+	if len(stream) < 4 || !strings.EqualFold(stream[:4], "url=") {
+		return nil, errors.New("rtp-info has no url")
+	}
+	stream = stream[4:]
+	var info RtpInfo
+	if strings.HasPrefix(stream, "\"") {
+		end := strings.IndexByte(stream[1:], '"')
+		if end == -1 {
+			return nil, errors.New("unterminated rtp-info url")
+		}
+		info.Url = stream[1 : end+1]
+		stream = stream[end+2:]
+	} else {
+		end := strings.IndexAny(stream, "; \t")
+		if end == -1 {
+			end = len(stream)
+		}
+		info.Url = stream[:end]
+		stream = stream[end:]
+	}
+	if info.Url == "" {
+		return nil, errors.New("rtp-info has no url")
+	}
+	ssrcs := strings.Fields(stream)
+	if len(ssrcs) == 0 || !strings.HasPrefix(strings.ToLower(ssrcs[0]), "ssrc=") {
+		err := info.parseParams(stream)
+		return []RtpInfo{info}, err
+	}
+	var infos []RtpInfo
+	for _, ssrc := range ssrcs {
+		if len(ssrc) <= 5 || !strings.EqualFold(ssrc[:5], "ssrc=") {
+			return nil, errors.New("wrong rtp-info ssrc " + ssrc)
+		}
+		elem := info
+		kv := strings.SplitN(ssrc[5:], ":", 2)
+		id, err := strconv.ParseUint(kv[0], 16, 32)
+		if err != nil {
+			return nil, errors.New("wrong rtp-info ssrc " + kv[0])
+		}
+		elem.Ssrc = uint32(id)
+		elem.HasSsrc = true
+		if len(kv) == 2 {
+			if err = elem.parseParams(kv[1]); err != nil {
+				return nil, err
+			}
+		}
+		infos = append(infos, elem)
+	}
+	return infos, nil
+}
+
+func ParseRtpInfo(rtpinfo string) ([]RtpInfo, error) {
+	var infos []RtpInfo
+	for _, stream := range splitList(rtpinfo) {
+		streamInfos, err := parseRtpInfoStream(stream)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, streamInfos...)
+	}
+	return infos, nil
+}
+
+// Accept-Ranges: npt, clock, smpte
+type AcceptRanges []string
+
+func ParseAcceptRanges(ranges string) AcceptRanges {
+	var result AcceptRanges
+	for _, unit := range strings.Split(ranges, ",") {
+		if unit = strings.TrimSpace(unit); unit != "" {
+			result = append(result, strings.ToLower(unit))
+		}
+	}
+	return result
+}
+
+func (a AcceptRanges) Has(unit string) bool {
+	for _, elem := range a {
+		if strings.EqualFold(elem, unit) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a AcceptRanges) String() string {
+	return strings.Join(a, ", ")
+}
+
+// Media-Properties: Random-Access=2.5, Unlimited, Immutable, Scales="-20, -10, 0.5:1.5, 4"
+type MediaProperties struct {
+	RandomAccess         string  //Random-Access, Beginning-Only or No-Seeking
+	MaxRandomAccessDelta float64 //seconds, zero if not given
+	Content              string  //Immutable, Dynamic or Time-Progressing
+	Retention            string  //Unlimited, Time-Limited or Time-Duration
+	TimeLimited          time.Time
+	Duration             float64  //Time-Duration in seconds
+	Scales               []string //single scales or ranges like 0.5:1.5
+	Extra                []string
+}
+
+func ParseMediaProperties(properties string) (MediaProperties, error) {
+	var p MediaProperties
+	for _, property := range splitList(properties) {
+		property = strings.TrimSpace(property)
+		if property == "" {
+			continue
+		}
+		kv := strings.SplitN(property, "=", 2)
+		var value string
+		if len(kv) == 2 {
+			value = strings.Trim(strings.TrimSpace(kv[1]), "\"")
+		}
+		var err error
+		switch strings.ToLower(kv[0]) {
+		case "random-access":
+			p.RandomAccess = "Random-Access"
+			if value != "" {
+				if p.MaxRandomAccessDelta, err = strconv.ParseFloat(value, 64); err != nil {
+					return p, errors.New("wrong media properties random-access " + value)
+				}
+			}
+		case "beginning-only":
+			p.RandomAccess = "Beginning-Only"
+		case "no-seeking":
+			p.RandomAccess = "No-Seeking"
+		case "immutable":
+			p.Content = "Immutable"
+		case "dynamic":
+			p.Content = "Dynamic"
+		case "time-progressing":
+			p.Content = "Time-Progressing"
+		case "unlimited":
+			p.Retention = "Unlimited"
+		case "time-limited":
+			p.Retention = "Time-Limited"
+			if p.TimeLimited, err = parseClock(value); err != nil {
+				return p, err
+			}
+		case "time-duration":
+			p.Retention = "Time-Duration"
+			if p.Duration, err = strconv.ParseFloat(value, 64); err != nil {
+				return p, errors.New("wrong media properties time-duration " + value)
+			}
+		case "scales":
+			for _, scale := range strings.Split(value, ",") {
+				p.Scales = append(p.Scales, strings.TrimSpace(scale))
+			}
+		default:
+			p.Extra = append(p.Extra, property)
+		}
+	}
+	return p, nil
+}
+
+func (p MediaProperties) String() string {
+	var properties []string
+	if p.RandomAccess != "" {
+		if p.RandomAccess == "Random-Access" && p.MaxRandomAccessDelta > 0 {
+			properties = append(properties, "Random-Access="+strconv.FormatFloat(p.MaxRandomAccessDelta, 'f', -1, 64))
+		} else {
+			properties = append(properties, p.RandomAccess)
+		}
+	}
+	if p.Content != "" {
+		properties = append(properties, p.Content)
+	}
+	switch p.Retention {
+	case "Unlimited":
+		properties = append(properties, p.Retention)
+	case "Time-Limited":
+		properties = append(properties, "Time-Limited="+p.TimeLimited.UTC().Format(onvifClockFormat))
+	case "Time-Duration":
+		properties = append(properties, "Time-Duration="+strconv.FormatFloat(p.Duration, 'f', -1, 64))
+	}
+	if len(p.Scales) > 0 {
+		properties = append(properties, "Scales=\""+strings.Join(p.Scales, ", ")+"\"")
+	}
+	properties = append(properties, p.Extra...)
+	return strings.Join(properties, ", ")
+}
@@ -0,0 +1,180 @@
+package rtsp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	clock := func(value string) time.Time {
+		tm, _ := time.Parse("20060102T150405.999Z", value)
+		return tm
+	}
+	tests := []struct {
+		header string
+		want   Range
+		str    string //of want, the header if empty
+	}{
+		{"npt=0-", Range{Unit: RangeNpt, End: -1}, ""},
+		{"npt=10.5-20", Range{Unit: RangeNpt, Start: 10500 * time.Millisecond, End: 20 * time.Second}, ""},
+		{"npt=now-", Range{Unit: RangeNpt, Now: true, End: -1}, ""},
+		{"npt=-20", Range{Unit: RangeNpt, End: 20 * time.Second}, "npt=0-20"},
+		{"npt=00:01:02.5-01:00:00", Range{Unit: RangeNpt, Start: 62500 * time.Millisecond, End: time.Hour}, "npt=62.5-3600"},
+		{"NPT = 3 - 4 ;time=19970123T143720Z", Range{Unit: RangeNpt, Start: 3 * time.Second, End: 4 * time.Second}, "npt=3-4"},
+		{"clock=19961108T142300Z-19961108T143520Z", Range{Unit: RangeClock, StartTime: clock("19961108T142300Z"), EndTime: clock("19961108T143520Z")},
+			"clock=19961108T142300.000Z-19961108T143520.000Z"},
+		{"clock=20240102T030405.250Z-", Range{Unit: RangeClock, StartTime: clock("20240102T030405.250Z")}, ""},
+		{"smpte=10:07:00-10:07:33:05.01", Range{Unit: RangeSmpte, SmpteStart: SmpteTime{Hours: 10, Minutes: 7},
+			SmpteEnd: SmpteTime{Hours: 10, Minutes: 7, Seconds: 33, Frames: 5, Subframes: 1}, HasSmpteEnd: true}, ""},
+		{"smpte-25=0:10:20:05-", Range{Unit: RangeSmpte25, SmpteStart: SmpteTime{Minutes: 10, Seconds: 20, Frames: 5}}, ""},
+		{"smpte-30-drop=0:00:10-0:00:20", Range{Unit: RangeSmpte30Drop, SmpteStart: SmpteTime{Seconds: 10},
+			SmpteEnd: SmpteTime{Seconds: 20}, HasSmpteEnd: true}, ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseRange(tt.header)
+		if err != nil {
+			t.Errorf("%s: %v", tt.header, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.header, got, tt.want)
+		}
+		str := tt.str
+		if str == "" {
+			str = tt.header
+		}
+		if got.String() != str {
+			t.Errorf("%s: String is %s, want %s", tt.header, got.String(), str)
+		}
+		if again, err := ParseRange(got.String()); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("%s: %s parses to %+v %v", tt.header, got.String(), again, err)
+		}
+	}
+}
+
+func TestParseRangeMalformed(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"npt",
+		"npt=10",
+		"npt=ten-",
+		"npt=1:2-",
+		"npt=0-x",
+		"clock=-",
+		"clock=19961108-",
+		"clock=19961108T142300Z-tomorrow",
+		"smpte=10:07-",
+		"smpte=10:07:aa-",
+		"smpte=10:07:00-10:07:33:05.xx",
+		"frames=0-10",
+	} {
+		if r, err := ParseRange(header); err == nil {
+			t.Errorf("%q: got %+v", header, r)
+		}
+	}
+}
+
+func TestParseRtpInfo(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []RtpInfo
+		str    string //of the elements joined with commas, the header if empty
+	}{
+		{"url=rtsp://cam/live/trackID=1;seq=45102;rtptime=12345",
+			[]RtpInfo{{Url: "rtsp://cam/live/trackID=1", Seq: 45102, HasSeq: true, RtpTime: 12345, HasRtpTime: true}}, ""},
+		{"url=rtsp://cam/live/trackID=1;seq=1;rtptime=4294967295,url=rtsp://cam/live/trackID=2;seq=65535",
+			[]RtpInfo{
+				{Url: "rtsp://cam/live/trackID=1", Seq: 1, HasSeq: true, RtpTime: 4294967295, HasRtpTime: true},
+				{Url: "rtsp://cam/live/trackID=2", Seq: 65535, HasSeq: true},
+			}, ""},
+		{"url=rtsp://cam/live", []RtpInfo{{Url: "rtsp://cam/live"}}, ""},
+		{"URL=rtsp://cam/live; Seq=7 ; RTPTIME=8", []RtpInfo{{Url: "rtsp://cam/live", Seq: 7, HasSeq: true, RtpTime: 8, HasRtpTime: true}},
+			"url=rtsp://cam/live;seq=7;rtptime=8"},
+		//rtsp 2.0, a quoted url may hold commas and several ssrc
+		{`url="rtsp://cam/a,b/trackID=1" ssrc=0A13C760:seq=45102;rtptime=12345 ssrc=9A9DE123:seq=30211;rtptime=29567112`,
+			[]RtpInfo{
+				{Url: "rtsp://cam/a,b/trackID=1", Ssrc: 0x0A13C760, HasSsrc: true, Seq: 45102, HasSeq: true, RtpTime: 12345, HasRtpTime: true},
+				{Url: "rtsp://cam/a,b/trackID=1", Ssrc: 0x9A9DE123, HasSsrc: true, Seq: 30211, HasSeq: true, RtpTime: 29567112, HasRtpTime: true},
+			}, `url="rtsp://cam/a,b/trackID=1" ssrc=0A13C760:seq=45102;rtptime=12345,url="rtsp://cam/a,b/trackID=1" ssrc=9A9DE123:seq=30211;rtptime=29567112`},
+		{`url="rtsp://cam/live" ssrc=0A13C760`, []RtpInfo{{Url: "rtsp://cam/live", Ssrc: 0x0A13C760, HasSsrc: true}}, ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseRtpInfo(tt.header)
+		if err != nil {
+			t.Errorf("%s: %v", tt.header, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.header, got, tt.want)
+		}
+		var str string
+		for i, info := range got {
+			if i > 0 {
+				str += ","
+			}
+			str += info.String()
+		}
+		if want := tt.str; want == "" && str != tt.header || want != "" && str != want {
+			t.Errorf("%s: String is %s", tt.header, str)
+		}
+		if again, err := ParseRtpInfo(str); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("%s: %s parses to %+v %v", tt.header, str, again, err)
+		}
+	}
+}
+
+func TestParseRtpInfoMalformed(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"seq=1;rtptime=2",
+		"url=;seq=1",
+		"url=rtsp://cam/live;seq=65536",
+		"url=rtsp://cam/live;seq=-1",
+		"url=rtsp://cam/live;rtptime=4294967296",
+		"url=rtsp://cam/live;rtptime=abc",
+		"url=rtsp://cam/live;seq=1,url",
+		`url="rtsp://cam/live ssrc=0A13C760`,
+		`url="rtsp://cam/live" ssrc=XYZ:seq=1`,
+		`url="rtsp://cam/live" ssrc=:seq=1`,
+		`url="rtsp://cam/live" ssrc=0A13C760:seq=1 seq=2`,
+	} {
+		if infos, err := ParseRtpInfo(header); err == nil {
+			t.Errorf("%q: got %+v", header, infos)
+		}
+	}
+}
+
+func TestParseSession(t *testing.T) {
+	tests := []struct {
+		header string
+		want   Session
+		str    string
+		err    bool
+	}{
+		{"12345678", Session{ID: "12345678"}, "12345678", false},
+		{"12345678;timeout=60", Session{ID: "12345678", Timeout: 60}, "12345678;timeout=60", false},
+		{" 6D3A5F3B ; Timeout=30", Session{ID: "6D3A5F3B", Timeout: 30}, "6D3A5F3B;timeout=30", false},
+		//unknown parameters are ignored
+		{"abc$-_.+;foo=bar;timeout=5", Session{ID: "abc$-_.+", Timeout: 5}, "abc$-_.+;timeout=5", false},
+		{"", Session{}, "", true},
+		{";timeout=60", Session{}, "", true},
+		{"12345678;timeout=sixty", Session{}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSession(tt.header)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.header, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if got != tt.want || got.String() != tt.str {
+			t.Errorf("%q: got %+v %s", tt.header, got, got.String())
+		}
+		if again, err := ParseSession(got.String()); err != nil || again != got {
+			t.Errorf("%q: %s parses to %+v %v", tt.header, got.String(), again, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package rtsp
+
+import (
+	"time"
+)
+
+type KeepAliveStrategy int
+
+const (
+	//GET_PARAMETER if the server lists it in Public, otherwise OPTIONS
+	KeepAliveAuto KeepAliveStrategy = iota
+	KeepAliveOptions
+	KeepAliveGetParameter
+	//rtcp receiver reports on the interleaved rtcp channels
+	KeepAliveRtcp
+	KeepAliveNone
+)
+
+// SetKeepAliveStrategy must be called before Start
+func (c *Rtspclient) SetKeepAliveStrategy(strategy KeepAliveStrategy) {
+	c.aliveStrategy = strategy
+}
+
+// keepAliveStrategy and keepAliveInterval are called holding mtx
+func (c *Rtspclient) keepAliveStrategy() KeepAliveStrategy {
+	if c.aliveStrategy == KeepAliveAuto {
+		if c.getParameter {
+			return KeepAliveGetParameter
+		}
+		return KeepAliveOptions
+	}
+	return c.aliveStrategy
+}
+
+func (c *Rtspclient) keepAliveInterval() time.Duration {
+	timeout := c.aliveTimeout
+	if timeout <= 0 {
+		timeout = 60
+	}
+	//send twice in a session timeout
+	if timeout < 2 {
+		return time.Second / 2
+	}
+	return time.Second * time.Duration(timeout/2)
+}
+
+// keepAliveLoop ends with the connection of quit
+func (c *Rtspclient) keepAliveLoop(quit chan struct{}, strategy KeepAliveStrategy, interval time.Duration) {
+	if strategy == KeepAliveNone {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		var err error
+		switch strategy {
+		case KeepAliveOptions:
+			err = c.sendRequestWith(MakeOption(c.url), nil)
+		case KeepAliveGetParameter:
+			err = c.sendRequestWith(MakeGetParameter(c.url), nil)
+		case KeepAliveRtcp:
+			err = c.sendReceiverReports()
+		}
+		if err != nil {
+			c.logWarn("keepalive failed", "error", err)
+		}
+	}
+}
+
+func (c *Rtspclient) sendReceiverReports() error {
+	var packets [][]byte
+	now := time.Now()
+	c.statsMtx.Lock()
+	for i := range c.mediaChanel {
+		media := &c.mediaChanel[i]
+		if media.RtcpChannel < 0 || media.backchannel {
+			continue
+		}
+		rr := receiverReport{ssrc: c.rtcpSsrc}
+		if media.received {
+			rr.blocks = append(rr.blocks, media.reportBlock(now))
+		}
+		packet := append(rr.encode(), encodeSdesCname(c.rtcpSsrc, "rtspclient")...)
+		packet = append(packet, encodeXrRRTR(c.rtcpSsrc, now)...)
+		interleaved := []byte{'$', byte(media.RtcpChannel), byte(len(packet) >> 8), byte(len(packet))}
+		packets = append(packets, append(interleaved, packet...))
+	}
+	c.statsMtx.Unlock()
+	for _, packet := range packets {
+		if err := c.write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportBlock is rfc3550 6.4.1 and A.3, called holding statsMtx
+func (m *meidaTransport) reportBlock(now time.Time) reportBlock {
+	block := reportBlock{ssrc: m.ssrc, highestSeq: m.highestSeq, jitter: uint32(m.stats.jitter), lsr: m.lsr}
+	if lost := m.lost(); lost > 0x7FFFFF {
+		block.totalLost = 0x7FFFFF
+	} else if lost > 0 {
+		block.totalLost = uint32(lost)
+	}
+	expected := m.highestSeq - m.baseSeq + 1
+	expectedInterval := expected - m.expectedPrior
+	receivedInterval := m.packetsReceived - m.receivedPrior
+	m.expectedPrior = expected
+	m.receivedPrior = m.packetsReceived
+	if lostInterval := int64(expectedInterval) - int64(receivedInterval); expectedInterval > 0 && lostInterval > 0 {
+		block.fractionLost = uint8(lostInterval << 8 / int64(expectedInterval))
+	}
+	if m.lsr != 0 {
+		block.dlsr = uint32(now.Sub(m.lsrTime) * 65536 / time.Second)
+	}
+	return block
+}
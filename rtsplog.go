@@ -0,0 +1,158 @@
+package rtsp
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Logger receives a message and key-value pairs, like log/slog.
+// A *slog.Logger can be passed as it is
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// stdLogger writes "LEVEL msg key=value ..." lines to a *log.Logger
+type stdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewStdLogger logs the messages from level on, to stderr if logger is nil
+func NewStdLogger(logger *log.Logger, level LogLevel) Logger {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &stdLogger{logger: logger, level: level}
+}
+
+func (l *stdLogger) Debug(msg string, keyvals ...interface{}) { l.log(LevelDebug, msg, keyvals) }
+func (l *stdLogger) Info(msg string, keyvals ...interface{})  { l.log(LevelInfo, msg, keyvals) }
+func (l *stdLogger) Warn(msg string, keyvals ...interface{})  { l.log(LevelWarn, msg, keyvals) }
+func (l *stdLogger) Error(msg string, keyvals ...interface{}) { l.log(LevelError, msg, keyvals) }
+
+func (l *stdLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+	var line strings.Builder
+	line.WriteString(level.String())
+	line.WriteString(" ")
+	line.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		line.WriteString(" ")
+		line.WriteString(fmt.Sprint(keyvals[i]))
+		line.WriteString("=")
+		if i+1 < len(keyvals) {
+			value := fmt.Sprint(keyvals[i+1])
+			if strings.ContainsAny(value, " \r\n\"=") {
+				value = fmt.Sprintf("%q", value)
+			}
+			line.WriteString(value)
+		}
+	}
+	l.logger.Output(3, line.String())
+}
+
+type loggerHolder struct {
+	logger Logger
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(loggerHolder{nopLogger{}})
+}
+
+// SetDefaultLogger is used by the clients built afterwards and by the rtsp
+// message and rtp parsers, which belong to no client. Nothing is logged by default
+func SetDefaultLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	defaultLogger.Store(loggerHolder{logger})
+}
+
+func packageLogger() Logger {
+	return defaultLogger.Load().(loggerHolder).logger
+}
+
+// SetLogger must be called before Start
+func (c *Rtspclient) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	c.logger = logger
+}
+
+// the url and the session are added to every entry of a client, they are
+// kept aside so the entries can be written holding mtx
+type logFields struct {
+	url     string
+	session string
+}
+
+func (c *Rtspclient) updateLogFields(url string, session string) {
+	c.fields.Store(logFields{url: url, session: session})
+}
+
+func (c *Rtspclient) withFields(keyvals []interface{}) []interface{} {
+	fields, _ := c.fields.Load().(logFields)
+	all := make([]interface{}, 0, len(keyvals)+4)
+	all = append(all, "url", fields.url)
+	if fields.session != "" {
+		all = append(all, "session", fields.session)
+	}
+	return append(all, keyvals...)
+}
+
+func (c *Rtspclient) logDebug(msg string, keyvals ...interface{}) {
+	c.logger.Debug(msg, c.withFields(keyvals)...)
+}
+
+func (c *Rtspclient) logInfo(msg string, keyvals ...interface{}) {
+	c.logger.Info(msg, c.withFields(keyvals)...)
+}
+
+func (c *Rtspclient) logWarn(msg string, keyvals ...interface{}) {
+	c.logger.Warn(msg, c.withFields(keyvals)...)
+}
+
+func (c *Rtspclient) logError(msg string, keyvals ...interface{}) {
+	c.logger.Error(msg, c.withFields(keyvals)...)
+}
@@ -2,9 +2,6 @@ package rtsp
 
 import (
 	"bytes"
-	"crypto/md5"
-	"fmt"
-	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -18,83 +15,44 @@ const (
 	Failed
 )
 
-type Message interface {
-	Decode(msg []byte) ParserState
-	ToString() int
-}
-
-type Authenticate interface {
-	authenticateInfo() string
-	parse(authenticate string) error
-}
-
-type DigestAuthenticate struct {
-	realm    string
-	nonce    string
-	username string
-	uri      string
-	method   string
-	password string
-}
+const (
+	RTSP10 = "RTSP/1.0"
+	RTSP20 = "RTSP/2.0" //rfc7826
+)
 
-func (auth DigestAuthenticate) digestInfo() string {
-	digest := fmt.Sprintf("Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", response=\"%s\"",
-		auth.username, auth.realm, auth.nonce, auth.uri, auth.authenticateInfo())
-	return digest
+func validVersion(version string) bool {
+	return version == RTSP10 || version == RTSP20
 }
 
-//response=md5(md5(username:realm:password):nonce:md5(method:url));
-func (auth DigestAuthenticate) authenticateInfo() string {
-	str1 := auth.username + ":" + auth.realm + ":" + auth.password
-	str2 := auth.method + ":" + auth.uri
-	md5Bytes1 := md5.Sum([]byte(str1))
-	md5Bytes2 := md5.Sum([]byte(str2))
-	md5str1 := fmt.Sprintf("%x", md5Bytes1)
-	md5str2 := fmt.Sprintf("%x", md5Bytes2)
-	str3 := md5str1 + ":" + auth.nonce + ":" + md5str2
-	md5Bytes3 := md5.Sum([]byte(str3))
-	response := fmt.Sprintf("%x", md5Bytes3)
-	return response
+type Message interface {
+	Decode(msg []byte) ParserState
+	ToString() int
 }
 
-func (auth *DigestAuthenticate) parse(authenticate string) error {
-	elems := strings.Split(authenticate, ",")
-	for i := 0; i < len(elems); i++ {
-		elem := strings.TrimSpace(elems[i])
-		if strings.Contains(elem, "realm") {
-			fmt.Sscanf(elem, "realm=\"%s\"", &auth.realm)
-			auth.realm = auth.realm[:len(auth.realm)-1]
-		} else if strings.Contains(elem, "nonce") {
-			fmt.Sscanf(elem, "nonce=\"%s\"", &auth.nonce)
-			auth.nonce = auth.nonce[:len(auth.nonce)-1]
-		}
-	}
-
-	return nil
-}
+// the HeaderFileds map of Request and Response was replaced by Header,
+// HeaderFromMap and Header.Map convert between the two, the README shows
+// how to migrate
 
 type Request struct {
-	Method       string
-	Uri          string
-	Version      string
-	HeaderFileds map[string]string
-	Body         []byte
+	Method  string
+	Uri     string
+	Version string
+	Header  Header
+	Body    []byte
+	//set by Decode
+	TotalLen int
 }
 
 type Response struct {
-	Version      string
-	StatusCode   string
-	Reason       string
-	HeaderFileds map[string]string
-	Body         []byte
-	TotalLen     int
-}
-
-type Transport interface {
-	Parser(transport string) int
-	ToString() string
+	Version    string
+	StatusCode string
+	Reason     string
+	Header     Header
+	Body       []byte
+	TotalLen   int
 }
 
+// Deprecated: TcpTransport only knows interleaved transports, use Transport
 type TcpTransport struct {
 	Interleaved [2]int
 	SSRC        string
@@ -102,60 +60,59 @@ type TcpTransport struct {
 }
 
 func (t *TcpTransport) Parser(transport string) int {
-	params := strings.Split(transport, ";")
-	for idx := range params {
-		param := params[idx]
-		if param == "RTP/AVP" {
-			return -1
-		} else if strings.Contains(param, "interleaved") {
-			fmt.Sscanf(param, "interleaved=%d-%d", &t.Interleaved[0], &t.Interleaved[1])
-		} else if strings.Contains(param, "ssrc") {
-			fmt.Sscanf(param, "ssrc=%s", &t.SSRC)
-		} else if strings.Contains(param, "mode") {
-			fmt.Sscanf(param, "mode=%s", &t.Mode)
-		}
+	trans, err := ParseTransport(transport)
+	if err != nil || !trans.IsTcp() {
+		return -1
 	}
+	t.Interleaved = trans.Interleaved
+	t.SSRC = trans.SSRC
+	t.Mode = trans.Mode
 	return 0
 }
 
 func (t TcpTransport) ToString() string {
-	var transport string
-	transport = "RTP/AVP/TCP;unicast"
-	transport += ";interleaved=" + strconv.Itoa(t.Interleaved[0]) + "-" + strconv.Itoa(t.Interleaved[1])
-	if t.SSRC != "" {
-		transport += ";ssrc=" + t.SSRC
-	}
-
-	if t.Mode != "" {
-		transport += ";mode=" + t.Mode
-	}
-
-	return transport
+	trans := NewTcpTransport(t.Interleaved[0], t.Mode)
+	trans.Interleaved = t.Interleaved
+	trans.SSRC = t.SSRC
+	return trans.String()
 }
 
-func (req Request) Decode(msg []byte) ParserState {
+// Decode parses a request sent by the server, like PLAY_NOTIFY in rtsp 2.0
+func (req *Request) Decode(msg []byte) ParserState {
+	startline, header, body, total, state := decodeMessage(msg)
+	if state != OK {
+		return state
+	}
+	elems := strings.Split(startline, " ")
+	if len(elems) != 3 || !validVersion(elems[2]) {
+		packageLogger().Debug("wrong request line", "line", startline)
+		return Failed
+	}
+	req.Method = elems[0]
+	req.Uri = elems[1]
+	req.Version = elems[2]
+	req.Header = header
+	req.Body = body
+	req.TotalLen = total
 	return OK
 }
 
 func (req *Request) ToString() string {
-	var request string
-	request = req.Method + " " + req.Uri + " " + req.Version + "\r\n"
-	for k, v := range req.HeaderFileds {
-		request += k + ": " + v + "\r\n"
-	}
-	request += "\r\n"
-	request += string(req.Body)
-	return request
+	var request strings.Builder
+	request.WriteString(req.Method + " " + req.Uri + " " + req.Version + "\r\n")
+	req.Header.write(&request)
+	request.WriteString("\r\n")
+	request.Write(req.Body)
+	return request.String()
 }
 
 func MakeOption(uri string) Request {
 	var req Request
 	req.Method = "OPTIONS"
 	req.Uri = uri
-	req.Version = "RTSP/1.0"
-	req.HeaderFileds = make(map[string]string)
-	req.HeaderFileds["Content-Length"] = "0"
-	req.HeaderFileds["Date"] = time.Now().UTC().Format("02 Jan 06 15:04:05 GMT")
+	req.Version = RTSP10
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Date", time.Now().UTC().Format("02 Jan 06 15:04:05 GMT"))
 	return req
 }
 
@@ -163,11 +120,10 @@ func MakeDescribe(uri string) Request {
 	var req Request
 	req.Method = "DESCRIBE"
 	req.Uri = uri
-	req.Version = "RTSP/1.0"
-	req.HeaderFileds = make(map[string]string)
-	req.HeaderFileds["Content-Length"] = "0"
-	req.HeaderFileds["Accept"] = "application/sdp"
-	req.HeaderFileds["Date"] = time.Now().UTC().Format("02 Jan 06 15:04:05 GMT")
+	req.Version = RTSP10
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Accept", "application/sdp")
+	req.Header.Set("Date", time.Now().UTC().Format("02 Jan 06 15:04:05 GMT"))
 	return req
 }
 
@@ -175,10 +131,9 @@ func MakeSetup(uri string) Request {
 	var req Request
 	req.Method = "SETUP"
 	req.Uri = uri
-	req.Version = "RTSP/1.0"
-	req.HeaderFileds = make(map[string]string)
-	req.HeaderFileds["Content-Length"] = "0"
-	req.HeaderFileds["Date"] = time.Now().UTC().Format("02 Jan 06 15:04:05 GMT")
+	req.Version = RTSP10
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Date", time.Now().UTC().Format("02 Jan 06 15:04:05 GMT"))
 	return req
 }
 
@@ -186,11 +141,40 @@ func MakePlay(uri string) Request {
 	var req Request
 	req.Method = "PLAY"
 	req.Uri = uri
-	req.Version = "RTSP/1.0"
-	req.HeaderFileds = make(map[string]string)
-	req.HeaderFileds["Content-Length"] = "0"
-	req.HeaderFileds["Accept"] = "application/sdp"
-	req.HeaderFileds["Date"] = time.Now().UTC().Format("02 Jan 06 15:04:05 GMT")
+	req.Version = RTSP10
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Accept", "application/sdp")
+	req.Header.Set("Date", time.Now().UTC().Format("02 Jan 06 15:04:05 GMT"))
+	return req
+}
+
+func MakePause(uri string) Request {
+	var req Request
+	req.Method = "PAUSE"
+	req.Uri = uri
+	req.Version = RTSP10
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Date", time.Now().UTC().Format("02 Jan 06 15:04:05 GMT"))
+	return req
+}
+
+func MakeGetParameter(uri string) Request {
+	var req Request
+	req.Method = "GET_PARAMETER"
+	req.Uri = uri
+	req.Version = RTSP10
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Date", time.Now().UTC().Format("02 Jan 06 15:04:05 GMT"))
+	return req
+}
+
+func MakeSetParameter(uri string) Request {
+	var req Request
+	req.Method = "SET_PARAMETER"
+	req.Uri = uri
+	req.Version = RTSP10
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Date", time.Now().UTC().Format("02 Jan 06 15:04:05 GMT"))
 	return req
 }
 
@@ -198,75 +182,95 @@ func MakeTearDown(uri string) Request {
 	var req Request
 	req.Method = "TEARDOWN"
 	req.Uri = uri
-	req.Version = "RTSP/1.0"
-	req.HeaderFileds = make(map[string]string)
-	req.HeaderFileds["Content-Length"] = "0"
-	req.HeaderFileds["Date"] = time.Now().UTC().Format("02 Jan 06 15:04:05 GMT")
+	req.Version = RTSP10
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Date", time.Now().UTC().Format("02 Jan 06 15:04:05 GMT"))
 	return req
 }
 
-func (res *Response) Decode(msg []byte) ParserState {
-	ret := bytes.HasPrefix(msg, []byte("RTSP/1.0"))
-	if !ret {
-		log.Println("message have no RTSP/1.0")
-		return Failed
+// a line starting with a space or tab continues the previous field
+func decodeHeader(header *Header, lines [][]byte) bool {
+	for _, line := range lines {
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && header.Len() > 0 {
+			last := &header.fields[header.Len()-1]
+			last.Value += " " + string(bytes.TrimSpace(line))
+			continue
+		}
+		kv := bytes.SplitN(line, []byte(":"), 2)
+		if len(kv) < 2 {
+			packageLogger().Debug("header line has no colon", "line", string(line))
+			return false
+		}
+		header.Add(string(kv[0]), string(bytes.TrimSpace(kv[1])))
 	}
+	return true
+}
+
+// decodeMessage splits msg into the start line, the header and the body,
+// total is the length of the whole message
+func decodeMessage(msg []byte) (startline string, header Header, body []byte, total int, state ParserState) {
 	idx := bytes.Index(msg, []byte("\r\n\r\n"))
 	if idx == -1 {
 		if len(msg) > 8196 {
-			log.Println("message too large")
-			return Failed
+			packageLogger().Debug("message too large", "length", len(msg))
+			return "", header, nil, 0, Failed
 		} else {
-			return InCompleted
+			return "", header, nil, 0, InCompleted
 		}
 	}
-	reslines := bytes.Split(msg[:idx], []byte("\r\n"))
-	if len(reslines) < 1 {
-		log.Println("res line is to small ")
+	lines := bytes.Split(msg[:idx], []byte("\r\n"))
+	if !decodeHeader(&header, lines[1:]) {
+		return "", header, nil, 0, Failed
+	}
+	total = idx + 4
+	length := header.Get("Content-Length")
+	if length != "" {
+		contentlen, err := strconv.Atoi(length)
+		if err != nil || contentlen < 0 {
+			packageLogger().Debug("wrong Content-Length", "length", length)
+			return "", header, nil, 0, Failed
+		}
+		if len(msg) < idx+4+contentlen {
+			return "", header, nil, 0, InCompleted
+		}
+		body = msg[idx+4 : idx+4+contentlen]
+		total += contentlen
+	}
+	return string(lines[0]), header, body, total, OK
+}
+
+func (res *Response) Decode(msg []byte) ParserState {
+	if !bytes.HasPrefix(msg, []byte("RTSP/")) {
+		packageLogger().Debug("response has no rtsp version")
 		return Failed
 	}
-	firstline := reslines[0]
-	elems := bytes.Split(firstline, []byte(" "))
+	startline, header, body, total, state := decodeMessage(msg)
+	if state != OK {
+		return state
+	}
+	elems := strings.SplitN(startline, " ", 3)
 	if len(elems) < 3 {
-		log.Println(("elem too small"))
+		packageLogger().Debug("wrong status line", "line", startline)
 		return Failed
 	}
-	res.Version = string(elems[0])
-	res.StatusCode = string(elems[1])
-	res.Reason = string(elems[2])
-	res.HeaderFileds = make(map[string]string)
-	for i := 1; i < len(reslines); i++ {
-		kv := bytes.SplitN(reslines[i], []byte(":"), 2)
-		if len(kv) < 2 {
-			log.Println("have no kv")
-			return Failed
-		}
-		key := kv[0]
-		value := bytes.TrimSpace(kv[1])
-		res.HeaderFileds[string(key)] = string(value)
-	}
-	res.TotalLen = idx + 4
-	length, ok := res.HeaderFileds["Content-Length"]
-	if ok {
-		contentlen, err := strconv.Atoi(length)
-		if err != nil {
-			log.Println("no content length")
-			return Failed
-		}
-		res.Body = msg[idx+4 : idx+4+contentlen]
-		res.TotalLen += contentlen
+	if !validVersion(elems[0]) {
+		packageLogger().Debug("unsupport version", "version", elems[0])
+		return Failed
 	}
-
+	res.Version = elems[0]
+	res.StatusCode = elems[1]
+	res.Reason = elems[2]
+	res.Header = header
+	res.Body = body
+	res.TotalLen = total
 	return OK
 }
 
 func (res *Response) ToString() string {
-	var response string
-	response = res.Version + " " + res.StatusCode + " " + res.Reason + "\r\n"
-	for k, v := range res.HeaderFileds {
-		response += k + ": " + v + "\r\n"
-	}
-	response += "\r\n"
-	response += string(res.Body)
-	return response
+	var response strings.Builder
+	response.WriteString(res.Version + " " + res.StatusCode + " " + res.Reason + "\r\n")
+	res.Header.write(&response)
+	response.WriteString("\r\n")
+	response.Write(res.Body)
+	return response.String()
 }
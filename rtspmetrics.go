@@ -0,0 +1,172 @@
+package rtsp
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricsExporter writes the Stats of the added clients in the Prometheus text
+// format, it is an http.Handler for a /metrics endpoint
+type MetricsExporter struct {
+	mtx     sync.Mutex
+	clients map[string]*Rtspclient
+}
+
+func NewMetricsExporter() *MetricsExporter {
+	return &MetricsExporter{clients: make(map[string]*Rtspclient)}
+}
+
+// Add exports the client with the label stream, a client added with the same
+// stream replaces the previous one
+func (e *MetricsExporter) Add(stream string, c *Rtspclient) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.clients[stream] = c
+}
+
+func (e *MetricsExporter) Remove(stream string) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	delete(e.clients, stream)
+}
+
+type streamStats struct {
+	stream string
+	stats  Stats
+}
+
+type sessionMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func(s Stats) float64
+}
+
+type trackMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func(t TrackStats) float64
+}
+
+var sessionMetrics = []sessionMetric{
+	{"rtsp_state", "State of the client, 0 idle, 1 connecting, 2 playing, 3 reconnecting, 4 stopped.", "gauge",
+		func(s Stats) float64 { return float64(s.State) }},
+	{"rtsp_reconnects_total", "Successful reconnects.", "counter",
+		func(s Stats) float64 { return float64(s.Reconnects) }},
+	{"rtsp_time_to_first_frame_seconds", "Time from Start or Dial to the first frame.", "gauge",
+		func(s Stats) float64 { return s.TimeToFirstFrame.Seconds() }},
+	{"rtsp_dropped_frames_total", "Frames dropped because the queue of Frames was full.", "counter",
+		func(s Stats) float64 { return float64(s.DroppedFrames) }},
+}
+
+var trackMetrics = []trackMetric{
+	{"rtsp_track_bytes_total", "Received rtp bytes, headers included.", "counter",
+		func(t TrackStats) float64 { return float64(t.Bytes) }},
+	{"rtsp_track_packets_total", "Received rtp packets.", "counter",
+		func(t TrackStats) float64 { return float64(t.Packets) }},
+	{"rtsp_track_bitrate_bits_per_second", "Bitrate over the last second.", "gauge",
+		func(t TrackStats) float64 { return t.Bitrate }},
+	{"rtsp_track_frames_total", "Decoded frames.", "counter",
+		func(t TrackStats) float64 { return float64(t.Frames) }},
+	{"rtsp_track_key_frames_total", "Decoded key frames.", "counter",
+		func(t TrackStats) float64 { return float64(t.KeyFrames) }},
+	{"rtsp_track_frame_rate", "Frames per second over the last second.", "gauge",
+		func(t TrackStats) float64 { return t.FrameRate }},
+	{"rtsp_track_key_frame_interval_seconds", "Time between the last two key frames.", "gauge",
+		func(t TrackStats) float64 { return t.KeyFrameInterval.Seconds() }},
+	{"rtsp_track_packets_lost", "Lost rtp packets, duplicates make it go down.", "gauge",
+		func(t TrackStats) float64 { return float64(t.PacketsLost) }},
+	{"rtsp_track_out_of_order_total", "Rtp packets received out of order.", "counter",
+		func(t TrackStats) float64 { return float64(t.OutOfOrder) }},
+	{"rtsp_track_jitter_seconds", "Interarrival jitter.", "gauge",
+		func(t TrackStats) float64 { return t.Jitter.Seconds() }},
+	{"rtsp_track_rtt_seconds", "Round trip time measured over rtcp.", "gauge",
+		func(t TrackStats) float64 { return t.RTT.Seconds() }},
+}
+
+func (e *MetricsExporter) snapshot() []streamStats {
+	e.mtx.Lock()
+	streams := make([]streamStats, 0, len(e.clients))
+	clients := make([]*Rtspclient, 0, len(e.clients))
+	for stream, c := range e.clients {
+		streams = append(streams, streamStats{stream: stream})
+		clients = append(clients, c)
+	}
+	e.mtx.Unlock()
+	for i, c := range clients {
+		streams[i].stats = c.Stats()
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].stream < streams[j].stream })
+	return streams
+}
+
+// WriteTo writes every metric of every client
+func (e *MetricsExporter) WriteTo(w io.Writer) (int64, error) {
+	streams := e.snapshot()
+	counter := &countWriter{w: w}
+	out := bufio.NewWriter(counter)
+	for _, metric := range sessionMetrics {
+		writeMetricHeader(out, metric.name, metric.help, metric.kind)
+		for _, s := range streams {
+			writeSample(out, metric.name, []string{"stream", s.stream}, metric.value(s.stats))
+		}
+	}
+	for _, metric := range trackMetrics {
+		writeMetricHeader(out, metric.name, metric.help, metric.kind)
+		for _, s := range streams {
+			for _, track := range s.stats.Tracks {
+				labels := []string{"stream", s.stream, "track", strconv.Itoa(track.Track), "media", track.Media, "codec", track.Codec.String()}
+				writeSample(out, metric.name, labels, metric.value(track))
+			}
+		}
+	}
+	err := out.Flush()
+	return counter.n, err
+}
+
+func (e *MetricsExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteTo(w)
+}
+
+func writeMetricHeader(out *bufio.Writer, name string, help string, kind string) {
+	out.WriteString("# HELP " + name + " " + help + "\n")
+	out.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+func writeSample(out *bufio.Writer, name string, labels []string, value float64) {
+	out.WriteString(name)
+	out.WriteString("{")
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		out.WriteString(labels[i] + "=\"" + escapeLabel(labels[i+1]) + "\"")
+	}
+	out.WriteString("} ")
+	out.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	out.WriteString("\n")
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
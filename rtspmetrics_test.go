@@ -0,0 +1,119 @@
+package rtsp
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func metricsClient(state State, tracks ...meidaTransport) *Rtspclient {
+	c := BuildRtspClient("rtsp://127.0.0.1/live")
+	c.state = state
+	c.mediaChanel = tracks
+	return c
+}
+
+const metricsGolden = `# HELP rtsp_state State of the client, 0 idle, 1 connecting, 2 playing, 3 reconnecting, 4 stopped.
+# TYPE rtsp_state gauge
+rtsp_state{stream="cam \"1\"\\door\nback"} 2
+rtsp_state{stream="lobby"} 3
+# HELP rtsp_reconnects_total Successful reconnects.
+# TYPE rtsp_reconnects_total counter
+rtsp_reconnects_total{stream="cam \"1\"\\door\nback"} 0
+rtsp_reconnects_total{stream="lobby"} 4
+# HELP rtsp_time_to_first_frame_seconds Time from Start or Dial to the first frame.
+# TYPE rtsp_time_to_first_frame_seconds gauge
+rtsp_time_to_first_frame_seconds{stream="cam \"1\"\\door\nback"} 0.25
+rtsp_time_to_first_frame_seconds{stream="lobby"} 0
+# HELP rtsp_dropped_frames_total Frames dropped because the queue of Frames was full.
+# TYPE rtsp_dropped_frames_total counter
+rtsp_dropped_frames_total{stream="cam \"1\"\\door\nback"} 0
+rtsp_dropped_frames_total{stream="lobby"} 0
+# HELP rtsp_track_bytes_total Received rtp bytes, headers included.
+# TYPE rtsp_track_bytes_total counter
+rtsp_track_bytes_total{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 1e+06
+rtsp_track_bytes_total{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 2000
+# HELP rtsp_track_packets_total Received rtp packets.
+# TYPE rtsp_track_packets_total counter
+rtsp_track_packets_total{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 800
+rtsp_track_packets_total{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 10
+# HELP rtsp_track_bitrate_bits_per_second Bitrate over the last second.
+# TYPE rtsp_track_bitrate_bits_per_second gauge
+rtsp_track_bitrate_bits_per_second{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 4.096e+06
+rtsp_track_bitrate_bits_per_second{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 0
+# HELP rtsp_track_frames_total Decoded frames.
+# TYPE rtsp_track_frames_total counter
+rtsp_track_frames_total{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 250
+rtsp_track_frames_total{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 10
+# HELP rtsp_track_key_frames_total Decoded key frames.
+# TYPE rtsp_track_key_frames_total counter
+rtsp_track_key_frames_total{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 5
+rtsp_track_key_frames_total{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 0
+# HELP rtsp_track_frame_rate Frames per second over the last second.
+# TYPE rtsp_track_frame_rate gauge
+rtsp_track_frame_rate{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 25
+rtsp_track_frame_rate{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 0
+# HELP rtsp_track_key_frame_interval_seconds Time between the last two key frames.
+# TYPE rtsp_track_key_frame_interval_seconds gauge
+rtsp_track_key_frame_interval_seconds{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 2
+rtsp_track_key_frame_interval_seconds{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 0
+# HELP rtsp_track_packets_lost Lost rtp packets, duplicates make it go down.
+# TYPE rtsp_track_packets_lost gauge
+rtsp_track_packets_lost{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 3
+rtsp_track_packets_lost{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} -1
+# HELP rtsp_track_out_of_order_total Rtp packets received out of order.
+# TYPE rtsp_track_out_of_order_total counter
+rtsp_track_out_of_order_total{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 7
+rtsp_track_out_of_order_total{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 0
+# HELP rtsp_track_jitter_seconds Interarrival jitter.
+# TYPE rtsp_track_jitter_seconds gauge
+rtsp_track_jitter_seconds{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 0.01
+rtsp_track_jitter_seconds{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 0
+# HELP rtsp_track_rtt_seconds Round trip time measured over rtcp.
+# TYPE rtsp_track_rtt_seconds gauge
+rtsp_track_rtt_seconds{stream="cam \"1\"\\door\nback",track="0",media="video",codec="H264"} 0.035
+rtsp_track_rtt_seconds{stream="cam \"1\"\\door\nback",track="1",media="audio",codec="AAC"} 0
+`
+
+func TestMetricsExporterWriteTo(t *testing.T) {
+	video := meidaTransport{media: "video", codec: H264, clockRate: 90000, stats: trackCounters{
+		bytes: 1000000, packets: 800, frames: 250, keyFrames: 5, bitrate: 4096000, frameRate: 25,
+		keyInterval: 2 * time.Second, lostBefore: 3, outOfOrder: 7, jitter: 900, rtt: 35 * time.Millisecond,
+	}}
+	//a duplicate packet makes the lost count negative
+	audio := meidaTransport{media: "audio", codec: AAC, stats: trackCounters{bytes: 2000, packets: 10, frames: 10, lostBefore: -1}}
+	backchannel := meidaTransport{media: "audio", codec: G711A, backchannel: true}
+	cam := metricsClient(StatePlaying, video, audio, backchannel)
+	cam.startTime = time.Unix(100, 0)
+	cam.firstFrame = cam.startTime.Add(250 * time.Millisecond)
+	lobby := metricsClient(StateReconnecting)
+	lobby.reconnects = 4
+
+	e := NewMetricsExporter()
+	e.Add("lobby", lobby)
+	e.Add("cam \"1\"\\door\nback", cam)
+	e.Add("removed", metricsClient(StateIdle))
+	e.Remove("removed")
+	var out bytes.Buffer
+	n, err := e.WriteTo(&out)
+	if err != nil || n != int64(out.Len()) {
+		t.Fatalf("wrote %d of %d bytes, %v", n, out.Len(), err)
+	}
+	if out.String() != metricsGolden {
+		got, want := strings.Split(out.String(), "\n"), strings.Split(metricsGolden, "\n")
+		for i := 0; i < len(got) && i < len(want); i++ {
+			if got[i] != want[i] {
+				t.Fatalf("line %d is\n%s\nwant\n%s", i+1, got[i], want[i])
+			}
+		}
+		t.Fatalf("got %d lines, want %d", len(got), len(want))
+	}
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Header().Get("Content-Type") != "text/plain; version=0.0.4; charset=utf-8" || w.Body.String() != metricsGolden {
+		t.Errorf("served %s", w.Header().Get("Content-Type"))
+	}
+}
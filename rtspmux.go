@@ -0,0 +1,226 @@
+package rtsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const defaultRequestTimeout = time.Second * 10
+
+// requests in flight are kept by CSeq until their response arrives, so
+// several requests may be outstanding on the control connection
+type pendingRequest struct {
+	req Request
+	//runs on the receive goroutine, before any following rtp packet is decoded
+	handle func(res Response) error
+	call   *call
+	//a stale nonce was already answered with new credentials
+	staleRetried bool
+}
+
+// call is a request whose sender waits for the response
+type call struct {
+	done chan struct{}
+	quit chan struct{} //of the connection the request was sent on
+	res  Response
+	err  error
+}
+
+// SetRequestTimeout limits the wait for a response, 10 seconds by default
+func (c *Rtspclient) SetRequestTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+func (c *Rtspclient) requestTimeout() time.Duration {
+	if c.timeout <= 0 {
+		return defaultRequestTimeout
+	}
+	return c.timeout
+}
+
+// send assigns a new CSeq to the request of p and writes it
+func (c *Rtspclient) send(p *pendingRequest) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.pending == nil {
+		return errors.New("rtsp client is not started")
+	}
+	cseq := c.prepareRequest(&p.req)
+	c.logDebug("send request", "method", p.req.Method, "uri", p.req.Uri, "cseq", cseq)
+	c.pending[cseq] = p
+	if p.call != nil && p.call.quit == nil {
+		p.call.quit = c.quit
+	}
+	//written holding mtx, so requests go out in CSeq order
+	err := c.sendRtspCommad([]byte(p.req.ToString()))
+	if err != nil {
+		delete(c.pending, cseq)
+	}
+	return err
+}
+
+// sendRequestWith does not wait, the response is passed to handle and an
+// error of handle closes the connection
+func (c *Rtspclient) sendRequestWith(req Request, handle func(res Response) error) error {
+	return c.send(&pendingRequest{req: req, handle: handle})
+}
+
+// start sends req, the response is passed to handle and then to the waiter of the call
+func (c *Rtspclient) start(req Request, handle func(res Response) error) (*call, error) {
+	cl := &call{done: make(chan struct{})}
+	p := &pendingRequest{req: req, handle: handle, call: cl}
+	if err := c.send(p); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+func (c *Rtspclient) wait(ctx context.Context, cl *call, method string) (Response, error) {
+	timer := time.NewTimer(c.requestTimeout())
+	defer timer.Stop()
+	select {
+	case <-cl.done:
+		return cl.res, cl.err
+	case <-timer.C:
+		c.cancel(cl)
+		return Response{}, fmt.Errorf("%s: %w", method, ErrTimeout)
+	case <-ctx.Done():
+		c.cancel(cl)
+		return Response{}, ctx.Err()
+	case <-cl.quit:
+		return Response{}, ErrClosed
+	}
+}
+
+// do sends req and waits for its response, it must not be called on the
+// receive goroutine, i.e. from OnFrame or OnNotify
+func (c *Rtspclient) do(ctx context.Context, req Request, handle func(res Response) error) (Response, error) {
+	cl, err := c.start(req, handle)
+	if err != nil {
+		return Response{}, err
+	}
+	return c.wait(ctx, cl, req.Method)
+}
+
+func (c *Rtspclient) takePending(cseq int) (*pendingRequest, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	p, ok := c.pending[cseq]
+	if ok {
+		delete(c.pending, cseq)
+	}
+	return p, ok
+}
+
+// cancel forgets a call given up by its waiter, whatever CSeq it was resent with
+func (c *Rtspclient) cancel(cl *call) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for cseq, p := range c.pending {
+		if p.call == cl {
+			delete(c.pending, cseq)
+		}
+	}
+}
+
+// dispatchResponse passes res to the request with the same CSeq, a request
+// refused with 401 or 505 is sent again with credentials or as rtsp 1.0,
+// handle is only called for a 2xx response
+func (c *Rtspclient) dispatchResponse(res Response) error {
+	cseq, _ := strconv.Atoi(res.Header.Get("CSeq"))
+	p, ok := c.takePending(cseq)
+	if !ok {
+		//a response whose waiter gave up
+		return nil
+	}
+	if res.StatusCode == "401" {
+		if err := c.parseChallenge(p, res); err != nil {
+			return p.finish(res, err)
+		}
+		return p.resend(c)
+	}
+	if c.negotiateVersion(res) {
+		p.req.Header = p.req.Header.Clone()
+		removeVersionHeaders(&p.req)
+		return p.resend(c)
+	}
+	if !successStatus(res) {
+		err := statusError(p.req, res)
+		if p.call != nil {
+			return p.finish(res, err)
+		}
+		//nobody waits for keepalives and seeks, only a lost session ends the connection
+		c.logWarn("request failed", "error", err, "cseq", cseq)
+		if errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+		return nil
+	}
+	var err error
+	if p.handle != nil {
+		err = p.handle(res)
+	}
+	return p.finish(res, err)
+}
+
+func (p *pendingRequest) resend(c *Rtspclient) error {
+	if err := c.send(p); err != nil {
+		return p.finish(Response{}, err)
+	}
+	return nil
+}
+
+// finish wakes the waiter of a call, the error of a request nobody waits for
+// is returned to the receive loop
+func (p *pendingRequest) finish(res Response, err error) error {
+	if p.call == nil {
+		return err
+	}
+	//body points into the receive buffer
+	res.Body = append([]byte(nil), res.Body...)
+	p.call.res = res
+	p.call.err = err
+	close(p.call.done)
+	return nil
+}
+
+// handshake creates the session and starts playing for Start, the requests
+// run on their own goroutine while cycleRecv reads the responses
+func (c *Rtspclient) handshake() {
+	if err := c.setupSession(context.Background()); err != nil {
+		c.logError("handshake failed", "error", err)
+		c.stopWith(err)
+	}
+}
+
+func (c *Rtspclient) setupSession(ctx context.Context) error {
+	if _, err := c.requestFollow(ctx, func() Request { return MakeOption(c.url) }, c.handleOption); err != nil {
+		return err
+	}
+	desc, err := c.Describe(ctx)
+	if err != nil {
+		return err
+	}
+	//a reconnect sets up the tracks chosen before
+	c.mtx.Lock()
+	tracks := append([]int(nil), c.selected...)
+	c.mtx.Unlock()
+	if len(tracks) == 0 {
+		for _, track := range desc.Tracks {
+			tracks = append(tracks, track.Index)
+		}
+	}
+	if c.pipelining && c.Version() == RTSP20 {
+		return c.setupPipelined(ctx, tracks)
+	}
+	for _, track := range tracks {
+		if err := c.Setup(ctx, track); err != nil {
+			return err
+		}
+	}
+	_, err = c.request(ctx, c.makePlay(), c.handlePlay)
+	return err
+}
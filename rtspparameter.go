@@ -0,0 +1,89 @@
+package rtsp
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// text/parameters body, one "name: value" per line
+func parseTextParameters(body []byte) map[string]string {
+	params := make(map[string]string)
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 {
+			params[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		} else {
+			params[line] = ""
+		}
+	}
+	return params
+}
+
+// a name or a value must not break the "name: value" lines of the body
+func checkTextParameter(name string, value string) error {
+	if name == "" || strings.ContainsAny(name, "\r\n:") {
+		return errors.New("wrong parameter name " + strconv.Quote(name))
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("wrong value of parameter " + name)
+	}
+	return nil
+}
+
+func setTextParameters(req *Request, body string) {
+	if body == "" {
+		return
+	}
+	req.Body = []byte(body)
+	req.Header.Set("Content-Type", "text/parameters")
+	req.Header.Set("Content-Length", strconv.Itoa(len(req.Body)))
+}
+
+// GetParameter asks the server for the named parameters, without names it
+// works as a ping and the returned map may be empty
+func (c *Rtspclient) GetParameter(ctx context.Context, names ...string) (map[string]string, error) {
+	var body string
+	for _, name := range names {
+		if err := checkTextParameter(name, ""); err != nil {
+			return nil, err
+		}
+		body += name + "\r\n"
+	}
+	req := MakeGetParameter(c.url)
+	setTextParameters(&req, body)
+	res, err := c.request(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseTextParameters(res.Body), nil
+}
+
+// SetParameter sends the parameters as text/parameters, sorted by name
+func (c *Rtspclient) SetParameter(ctx context.Context, params map[string]string) error {
+	if len(params) == 0 {
+		return errors.New("no parameter to set")
+	}
+	names := make([]string, 0, len(params))
+	for name, value := range params {
+		if err := checkTextParameter(name, value); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var body string
+	for _, name := range names {
+		body += name + ": " + params[name] + "\r\n"
+	}
+	req := MakeSetParameter(c.url)
+	setTextParameters(&req, body)
+	_, err := c.request(ctx, req, nil)
+	return err
+}
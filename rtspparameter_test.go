@@ -0,0 +1,68 @@
+package rtsp
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseTextParameters(t *testing.T) {
+	body := "position: 12.5\r\n  scale:2\nclock: 10:20:30\r\nnovalue\r\n\r\n"
+	want := map[string]string{"position": "12.5", "scale": "2", "clock": "10:20:30", "novalue": ""}
+	if got := parseTextParameters([]byte(body)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestClientParameters(t *testing.T) {
+	const body = "position: 12.5\r\nscale: 1\r\n"
+	s := startTestServer(t, &testServer{timeout: 60, reply: func(method string, header map[string]string) string {
+		switch {
+		case method == "GET_PARAMETER" && header["content-length"] != "" && header["content-length"] != "0":
+			return "RTSP/1.0 200 OK\r\nCSeq: " + header["cseq"] + "\r\nContent-Type: text/parameters\r\n" +
+				"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+		case method == "SET_PARAMETER":
+			return "RTSP/1.0 451 Parameter Not Understood\r\nCSeq: " + header["cseq"] + "\r\n\r\n"
+		}
+		return ""
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, s.url(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	params, err := c.GetParameter(ctx, "position", "scale")
+	if err != nil || !reflect.DeepEqual(params, map[string]string{"position": "12.5", "scale": "1"}) {
+		t.Errorf("got %v %v", params, err)
+	}
+	if params, err = c.GetParameter(ctx); err != nil || len(params) != 0 {
+		t.Errorf("ping got %v %v", params, err)
+	}
+	err = c.SetParameter(ctx, map[string]string{"scale": "2"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 451 || statusErr.Method != "SET_PARAMETER" {
+		t.Errorf("set got %v", err)
+	}
+
+	//a name or a value must not add lines or headers to the request
+	for _, name := range []string{"", "scale\r\nCSeq: 99", "a:b", "line\n"} {
+		if _, err = c.GetParameter(ctx, "position", name); err == nil {
+			t.Errorf("get %q: no error", name)
+		}
+		if err = c.SetParameter(ctx, map[string]string{name: "1"}); err == nil {
+			t.Errorf("set %q: no error", name)
+		}
+	}
+	if err = c.SetParameter(ctx, map[string]string{"scale": "1\r\n\r\nTEARDOWN"}); err == nil {
+		t.Error("set a value with a line break: no error")
+	}
+	if s.count("GET_PARAMETER") != 2 || s.count("SET_PARAMETER") != 1 {
+		t.Errorf("sent %d GET_PARAMETER and %d SET_PARAMETER", s.count("GET_PARAMETER"), s.count("SET_PARAMETER"))
+	}
+}
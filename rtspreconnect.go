@@ -0,0 +1,263 @@
+package rtsp
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type State int
+
+const (
+	StateIdle State = iota
+	StateConnecting
+	StatePlaying
+	StateReconnecting
+	StateStopped
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "Idle"
+	case StateConnecting:
+		return "Connecting"
+	case StatePlaying:
+		return "Playing"
+	case StateReconnecting:
+		return "Reconnecting"
+	case StateStopped:
+		return "Stopped"
+	}
+	return "Unknown"
+}
+
+// ReconnectPolicy redoes the whole handshake after the connection dropped,
+// the n-th attempt waits InitialDelay*Multiplier^(n-1), at most MaxDelay,
+// randomized by +-Jitter
+type ReconnectPolicy struct {
+	MaxAttempts  int //zero retries forever
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       float64 //0.2 spreads a delay over 80%-120%
+}
+
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     time.Second * 30,
+		Multiplier:   2,
+		Jitter:       0.2,
+	}
+}
+
+func (p ReconnectPolicy) delay(attempt int) time.Duration {
+	delay := float64(p.InitialDelay)
+	if delay <= 0 {
+		delay = float64(time.Second)
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// SetReconnectPolicy must be called before Start or Play
+func (c *Rtspclient) SetReconnectPolicy(policy ReconnectPolicy) {
+	c.mtx.Lock()
+	c.reconnect = &policy
+	c.mtx.Unlock()
+}
+
+// State is the state last passed to OnStateChange
+func (c *Rtspclient) State() State {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.state
+}
+
+// setState calls OnStateChange, err is why the connection was lost or the client stopped
+func (c *Rtspclient) setState(state State, err error) {
+	c.mtx.Lock()
+	if c.state == state && state != StateReconnecting {
+		c.mtx.Unlock()
+		return
+	}
+	c.state = state
+	c.mtx.Unlock()
+	if c.OnStateChange != nil {
+		c.OnStateChange(state, err)
+	}
+}
+
+// timeline keeps the timestamps of a track increasing across reconnects and
+// seeks, the rtptime of RTP-Info, or else the first frame of a new connection,
+// follows the last frame by a frame duration
+type timeline struct {
+	offset  uint32
+	last    uint32
+	delta   uint32
+	started bool
+	rebase  bool
+	base    uint32 //rtptime of the last PLAY
+	hasBase bool
+}
+
+func (t *timeline) adjust(ts uint32) (uint32, bool) {
+	rebased := false
+	if t.rebase && t.started {
+		start := ts
+		if t.hasBase {
+			start = t.base
+		}
+		t.offset = t.last + t.delta - start
+		rebased = true
+	}
+	t.rebase = false
+	t.hasBase = false
+	out := ts + t.offset
+	if t.started && int32(out-t.last) > 0 {
+		t.delta = out - t.last
+	}
+	if !t.started || int32(out-t.last) > 0 {
+		t.last = out
+	}
+	t.started = true
+	return out, rebased
+}
+
+func (c *Rtspclient) adjustTimestamp(track int, frame *Frame) {
+	for len(c.timelines) <= track {
+		c.timelines = append(c.timelines, timeline{})
+	}
+	var rebased bool
+	frame.Ts, rebased = c.timelines[track].adjust(frame.Ts)
+	if rebased {
+		frame.Discontinuity = true
+	}
+}
+
+// rebaseTimeline takes the rtptime of RTP-Info, a seek rebases the track as a reconnect does
+func (c *Rtspclient) rebaseTimeline(track int, rtptime uint32, seek bool) {
+	for len(c.timelines) <= track {
+		c.timelines = append(c.timelines, timeline{})
+	}
+	c.timelines[track].base = rtptime
+	c.timelines[track].hasBase = true
+	if seek {
+		c.timelines[track].rebase = true
+	}
+}
+
+func (c *Rtspclient) rebaseTimelines() {
+	for i := range c.timelines {
+		c.timelines[i].rebase = true
+	}
+}
+
+// cancelOnStop is a context which ends when Stop is called
+func (c *Rtspclient) cancelOnStop() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := c.done
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// run is Start with a reconnect policy, the first connection is retried as well
+func (c *Rtspclient) run() {
+	if err := c.connectLoop(StateConnecting, nil); err != nil {
+		c.finish(err)
+		return
+	}
+	c.supervise()
+}
+
+// supervise waits for the connection to drop and connects again until Stop
+// is called or the attempts run out
+func (c *Rtspclient) supervise() {
+	for {
+		c.mtx.Lock()
+		quit := c.quit
+		c.mtx.Unlock()
+		select {
+		case <-quit:
+		case <-c.done:
+			return
+		}
+		//the receive goroutine must end before the timelines are rebased
+		c.dropConn()
+		if c.isStopped() {
+			return
+		}
+		c.logWarn("connection lost, reconnecting", "error", c.lostErr())
+		c.rebaseTimelines()
+		if err := c.connectLoop(StateReconnecting, c.lostErr()); err != nil {
+			c.finish(err)
+			return
+		}
+	}
+}
+
+func (c *Rtspclient) connectLoop(state State, cause error) error {
+	ctx, cancel := c.cancelOnStop()
+	defer cancel()
+	err := cause
+	for attempt := 1; ; attempt++ {
+		if c.reconnect.MaxAttempts > 0 && attempt > c.reconnect.MaxAttempts {
+			return err
+		}
+		c.setState(state, err)
+		if attempt > 1 || state == StateReconnecting {
+			timer := time.NewTimer(c.reconnect.delay(attempt))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ErrClosed
+			}
+		}
+		if err = c.connect(ctx); err == nil {
+			if err = c.setupSession(ctx); err == nil {
+				if state == StateReconnecting {
+					c.mtx.Lock()
+					c.reconnects++
+					c.mtx.Unlock()
+				}
+				return nil
+			}
+			c.dropConn()
+		}
+		c.logWarn("connect failed", "error", err, "attempt", attempt)
+	}
+}
+
+// dropConn closes the connection of a failed attempt and waits for cycleRecv
+func (c *Rtspclient) dropConn() {
+	c.mtx.Lock()
+	recvDone := c.recvDone
+	c.mtx.Unlock()
+	c.closeConn()
+	if recvDone != nil {
+		<-recvDone
+	}
+}
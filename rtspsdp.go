@@ -6,180 +6,489 @@ import (
 	"strings"
 )
 
-type attribute struct {
-	attr  string
-	value string
+// sdp as in rfc8866, which obsoletes rfc4566
+
+// Attribute is a=<Key>:<Value>, or a=<Key> for a property attribute
+type Attribute struct {
+	Key   string
+	Value string
 }
 
-func (a *attribute) parse(attrstr string) error {
+func (a *Attribute) parse(attrstr string) error {
 	strs := strings.SplitN(attrstr, ":", 2)
-	a.attr = strs[0]
+	a.Key = strs[0]
 	if len(strs) == 2 {
-		a.value = strs[1]
+		a.Value = strs[1]
 	}
 	return nil
 }
 
-type fmtpattr struct {
-	format    int
-	paramters string
+// Fmtp is a=fmtp:<format> <format specific parameters>
+type Fmtp struct {
+	Format int
+	Params string
 }
 
-//a=fmtp:<format> <format specific parameters>
-func (f *fmtpattr) parse(fmtpstr string) error {
-	strs := strings.SplitN(fmtpstr, " ", 2)
-	if len(strs) == 0 {
+func (f *Fmtp) parse(fmtpstr string) error {
+	strs := strings.SplitN(strings.TrimSpace(fmtpstr), " ", 2)
+	if len(strs) == 0 || strs[0] == "" {
 		return errors.New("fmtp string wrong format")
 	} else if len(strs) == 2 {
-		f.paramters = strs[1]
+		f.Params = strings.TrimSpace(strs[1])
 	}
-	f.format, _ = strconv.Atoi(strs[0])
+	f.Format, _ = strconv.Atoi(strs[0])
 	return nil
 }
 
-type rtpmapattr struct {
-	pt         int
-	encodeName string
-	clockRate  int
-	param      string
+// RtpMap is a=rtpmap:<payload type> <encoding name>/<clock rate>[/<encoding parameters>]
+type RtpMap struct {
+	PayloadType    int
+	EncodingName   string
+	ClockRate      int
+	EncodingParams string //number of channels for audio
+	static         bool   //filled in for a static payload type without rtpmap
 }
 
-//a=rtpmap:<payload type> <encoding name>/<clock rate> [/<encoding parameters>]
-func (r *rtpmapattr) parse(rtpmapstr string) error {
-	strs := strings.Split(rtpmapstr, " ")
+func (r *RtpMap) parse(rtpmapstr string) error {
+	strs := strings.Fields(rtpmapstr)
 	if len(strs) < 2 {
 		return errors.New("rtpmap is wring format")
 	}
-	r.pt, _ = strconv.Atoi(strs[0])
+	r.PayloadType, _ = strconv.Atoi(strs[0])
 	encodestr := strings.Split(strs[1], "/")
 	if len(encodestr) < 2 {
 		return errors.New("rtpmap is wring format")
 	}
-	r.encodeName = encodestr[0]
-	r.clockRate, _ = strconv.Atoi(encodestr[1])
+	r.EncodingName = encodestr[0]
+	r.ClockRate, _ = strconv.Atoi(encodestr[1])
 	if len(encodestr) == 3 {
-		r.param = encodestr[2]
+		r.EncodingParams = encodestr[2]
+	}
+	return nil
+}
+
+// Origin is o=<username> <sess-id> <sess-version> <nettype> <addrtype> <unicast-address>
+type Origin struct {
+	Username       string
+	SessionID      string
+	SessionVersion string
+	NetType        string
+	AddrType       string
+	Address        string
+}
+
+func (o *Origin) parse(originstr string) error {
+	strs := strings.Fields(originstr)
+	if len(strs) != 6 {
+		return errors.New("origin is wrong format")
+	}
+	o.Username, o.SessionID, o.SessionVersion = strs[0], strs[1], strs[2]
+	o.NetType, o.AddrType, o.Address = strs[3], strs[4], strs[5]
+	return nil
+}
+
+// Connection is c=<nettype> <addrtype> <connection-address>, a multicast
+// address may carry /<ttl> for IP4 and /<number of addresses>
+type Connection struct {
+	NetType  string
+	AddrType string
+	Address  string
+	TTL      int
+	NumAddrs int
+}
+
+func (c *Connection) parse(connstr string) error {
+	strs := strings.Fields(connstr)
+	if len(strs) != 3 {
+		return errors.New("connection is wrong format")
+	}
+	c.NetType, c.AddrType = strs[0], strs[1]
+	addr := strings.Split(strs[2], "/")
+	c.Address = addr[0]
+	var err error
+	switch {
+	case len(addr) == 2 && c.AddrType == "IP6":
+		c.NumAddrs, err = strconv.Atoi(addr[1])
+	case len(addr) >= 2:
+		c.TTL, err = strconv.Atoi(addr[1])
+		if err == nil && len(addr) == 3 {
+			c.NumAddrs, err = strconv.Atoi(addr[2])
+		}
+	}
+	if err != nil {
+		return errors.New("connection is wrong format")
+	}
+	return nil
+}
+
+// Bandwidth is b=<bwtype>:<bandwidth>, in kilobits per second for AS and CT
+type Bandwidth struct {
+	Type  string
+	Value int
+}
+
+func (b *Bandwidth) parse(bwstr string) error {
+	strs := strings.SplitN(bwstr, ":", 2)
+	if len(strs) != 2 {
+		return errors.New("bandwidth is wrong format")
+	}
+	b.Type = strs[0]
+	var err error
+	if b.Value, err = strconv.Atoi(strings.TrimSpace(strs[1])); err != nil {
+		return errors.New("bandwidth is wrong format")
+	}
+	return nil
+}
+
+// Timing is t=<start-time> <stop-time> in ntp seconds, zero means unbounded,
+// followed by its r=<repeat interval> <active duration> <offsets> lines
+type Timing struct {
+	Start   uint64
+	Stop    uint64
+	Repeats []string
+}
+
+func (t *Timing) parse(timestr string) error {
+	strs := strings.Fields(timestr)
+	if len(strs) != 2 {
+		return errors.New("timing is wrong format")
+	}
+	var err error
+	if t.Start, err = strconv.ParseUint(strs[0], 10, 64); err != nil {
+		return errors.New("timing is wrong format")
+	}
+	if t.Stop, err = strconv.ParseUint(strs[1], 10, 64); err != nil {
+		return errors.New("timing is wrong format")
 	}
 	return nil
 }
 
-//m=<media> <port> <proto> <fmt> ...
-type mediadescription struct {
-	media string
-	proto string
-	port  []int //for rtsp always zero
-	fmt   []int
+const (
+	DirectionSendRecv = "sendrecv"
+	DirectionSendOnly = "sendonly"
+	DirectionRecvOnly = "recvonly"
+	DirectionInactive = "inactive"
+)
+
+func isDirection(attr string) bool {
+	return attr == DirectionSendRecv || attr == DirectionSendOnly || attr == DirectionRecvOnly || attr == DirectionInactive
+}
+
+// MediaDescription is a m=<media> <port>[/<number of ports>] <proto> <fmt> ...
+// line and the lines up to the next m=
+type MediaDescription struct {
+	Media       string
+	Port        int //for rtsp always zero
+	NumPorts    int //zero if absent
+	Proto       string
+	Formats     []string //payload types for RTP/AVP
+	Title       string   //i=
+	Connections []Connection
+	Bandwidths  []Bandwidth
+	Key         string //k=
+	//every a= line of the media, in order
+	Attributes []Attribute
+	//taken from Attributes
+	Control string
+	//one per format, the static payload types of rfc3551 are filled in when
+	//they have no rtpmap
+	RtpMaps []RtpMap
+	Fmtps   []Fmtp
+	//of the first format
+	RtpMap    RtpMap
+	Fmtp      Fmtp
+	Direction string //the one of the session if the media has none
+	FrameRate float64
+	Range     Range
+	HasRange  bool
 }
 
-func (m *mediadescription) parse(mediadescribestr string) error {
-	strs := strings.Split(mediadescribestr, " ")
+func (m *MediaDescription) parse(mediadescribestr string) error {
+	strs := strings.Fields(mediadescribestr)
 	if len(strs) < 4 {
 		return errors.New("media describe is wring format")
 	}
-	m.media = strs[0]
-	if strings.Contains(strs[1], "/") {
-		portstr := strings.Split(strs[1], "/")
-		tmpport, _ := strconv.Atoi(portstr[0])
-		numberofports, _ := strconv.Atoi(portstr[1])
-		for i := 0; i < numberofports; i++ {
-			m.port = append(m.port, tmpport+i)
+	m.Media = strs[0]
+	portstr := strings.Split(strs[1], "/")
+	m.Port, _ = strconv.Atoi(portstr[0])
+	if len(portstr) == 2 {
+		m.NumPorts, _ = strconv.Atoi(portstr[1])
+	}
+	m.Proto = strs[2]
+	m.Formats = append([]string(nil), strs[3:]...)
+	return nil
+}
+
+// PayloadTypes are the numeric formats of the m= line
+func (m *MediaDescription) PayloadTypes() []int {
+	var pts []int
+	for _, format := range m.Formats {
+		if pt, err := strconv.Atoi(format); err == nil {
+			pts = append(pts, pt)
+		}
+	}
+	return pts
+}
+
+// rfc3551 6, the static payload types a client may meet without rtpmap
+var staticPayloadTypes = map[int]RtpMap{
+	0:  {PayloadType: 0, EncodingName: "PCMU", ClockRate: 8000},
+	8:  {PayloadType: 8, EncodingName: "PCMA", ClockRate: 8000},
+	26: {PayloadType: 26, EncodingName: "JPEG", ClockRate: 90000},
+	33: {PayloadType: 33, EncodingName: "MP2T", ClockRate: 90000},
+}
+
+// resolveFormats fills in the static payload types and the first format
+func (m *MediaDescription) resolveFormats() {
+	for _, pt := range m.PayloadTypes() {
+		if _, ok := m.RtpMapOf(pt); ok {
+			continue
+		}
+		if rtpmap, ok := staticPayloadTypes[pt]; ok {
+			rtpmap.static = true
+			m.RtpMaps = append(m.RtpMaps, rtpmap)
 		}
-	} else {
-		tmpport, _ := strconv.Atoi(strs[1])
-		m.port = append(m.port, tmpport)
 	}
-	m.proto = strs[2]
-	fmtnum := len(strs) - 3
-	m.fmt = make([]int, fmtnum)
-	for i := 0; i < fmtnum; i++ {
-		m.fmt[i], _ = strconv.Atoi(strs[3+i])
+	pts := m.PayloadTypes()
+	if len(pts) == 0 {
+		return
 	}
-	return nil
+	m.RtpMap, _ = m.RtpMapOf(pts[0])
+	m.Fmtp, _ = m.FmtpOf(pts[0])
+}
+
+// RtpMapOf returns the rtpmap of the payload type pt
+func (m *MediaDescription) RtpMapOf(pt int) (RtpMap, bool) {
+	for _, rtpmap := range m.RtpMaps {
+		if rtpmap.PayloadType == pt {
+			return rtpmap, true
+		}
+	}
+	return RtpMap{}, false
 }
 
-type sdpmedia struct {
-	describe   mediadescription
-	rtpmap     rtpmapattr
-	fmtp       fmtpattr
-	controlurl string
-	attrs      []attribute
+// FmtpOf returns the fmtp of the payload type pt
+func (m *MediaDescription) FmtpOf(pt int) (Fmtp, bool) {
+	for _, fmtp := range m.Fmtps {
+		if fmtp.Format == pt {
+			return fmtp, true
+		}
+	}
+	return Fmtp{}, false
 }
 
+// Attribute returns the value of the first a=<key> line
+func (m *MediaDescription) Attribute(key string) (string, bool) {
+	return findAttribute(m.Attributes, key)
+}
+
+func (m *MediaDescription) HasAttribute(key string) bool {
+	_, ok := m.Attribute(key)
+	return ok
+}
+
+func findAttribute(attrs []Attribute, key string) (string, bool) {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Rtspsdp is a session description, the fields of the v=, o=, s=, i=, u=, e=,
+// p=, c=, b=, t=, z=, k= and a= lines before the first m=
 type Rtspsdp struct {
-	Medias     []sdpmedia
+	Version       int
+	Origin        Origin
+	SessionName   string
+	Info          string
+	Uri           string
+	Emails        []string
+	Phones        []string
+	Connection    Connection
+	HasConnection bool
+	Bandwidths    []Bandwidth
+	Timings       []Timing
+	TimeZones     string
+	Key           string
+	//every session level a= line, in order
+	Attrs  []Attribute
+	Medias []MediaDescription
+	//taken from Attrs
 	Controlurl string
-	Attrs      []attribute
+	Direction  string //sendrecv if absent
+	Range      Range
+	HasRange   bool
 }
 
-func readLines(sdpstr string) []string {
-	start := 0
+// Attribute returns the value of the first session level a=<key> line
+func (s *Rtspsdp) Attribute(key string) (string, bool) {
+	return findAttribute(s.Attrs, key)
+}
+
+// splitLines accepts CRLF and LF, rfc8866 5
+func splitLines(sdpstr string) []string {
 	var result []string
-	for start < len(sdpstr) {
-		tmp := sdpstr[start:]
-		end := strings.IndexFunc(tmp, func(c rune) bool {
-			return c == '\r' || c == '\n'
-		})
-		if end == -1 {
-			break
-		} else {
-			result = append(result, tmp[0:end])
-			start += end + 1
-			if end < len(sdpstr)-1 && sdpstr[end+1] == '\n' {
-				start += 1
-			}
+	for _, line := range strings.Split(sdpstr, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line != "" {
+			result = append(result, line)
 		}
 	}
 	return result
 }
 
+// Parse reads a session description, unknown and malformed lines are skipped
+// except for m=, rtpmap and fmtp
 func Parse(sdpstr string) (Rtspsdp, error) {
-	lines := readLines(sdpstr)
 	result := Rtspsdp{}
-	mediaIdx := -1
-	for i := 0; i < len(lines); i++ {
-		if strings.Contains(lines[i], "m=") {
-			var mediaDes sdpmedia
-			err := mediaDes.describe.parse(lines[i][2:])
-			if err != nil {
+	var media *MediaDescription
+	for _, line := range splitLines(sdpstr) {
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		value := strings.TrimSpace(line[2:])
+		var err error
+		switch line[0] {
+		case 'v':
+			result.Version, err = strconv.Atoi(value)
+		case 'o':
+			err = result.Origin.parse(value)
+		case 's':
+			result.SessionName = value
+		case 'i':
+			if media == nil {
+				result.Info = value
+			} else {
+				media.Title = value
+			}
+		case 'u':
+			result.Uri = value
+		case 'e':
+			result.Emails = append(result.Emails, value)
+		case 'p':
+			result.Phones = append(result.Phones, value)
+		case 'c':
+			var conn Connection
+			if err = conn.parse(value); err != nil {
+				break
+			}
+			if media == nil {
+				result.Connection = conn
+				result.HasConnection = true
+			} else {
+				media.Connections = append(media.Connections, conn)
+			}
+		case 'b':
+			var bw Bandwidth
+			if err = bw.parse(value); err != nil {
+				break
+			}
+			if media == nil {
+				result.Bandwidths = append(result.Bandwidths, bw)
+			} else {
+				media.Bandwidths = append(media.Bandwidths, bw)
+			}
+		case 't':
+			var timing Timing
+			if err = timing.parse(value); err == nil {
+				result.Timings = append(result.Timings, timing)
+			}
+		case 'r':
+			if len(result.Timings) > 0 {
+				last := &result.Timings[len(result.Timings)-1]
+				last.Repeats = append(last.Repeats, value)
+			}
+		case 'z':
+			result.TimeZones = value
+		case 'k':
+			if media == nil {
+				result.Key = value
+			} else {
+				media.Key = value
+			}
+		case 'm':
+			var mediaDes MediaDescription
+			if err := mediaDes.parse(value); err != nil {
 				return Rtspsdp{}, err
 			}
 			result.Medias = append(result.Medias, mediaDes)
-			mediaIdx++
-		} else if strings.Contains(lines[i], "a=") {
-			var tmpattr attribute
-			tmpattr.parse(lines[i][2:])
-			switch {
-			case tmpattr.attr == "control":
-				if mediaIdx == -1 {
-					result.Controlurl = tmpattr.value
-				} else {
-					result.Medias[mediaIdx].controlurl = tmpattr.value
-				}
-			case tmpattr.attr == "rtpmap":
-				if mediaIdx == -1 {
-					return Rtspsdp{}, errors.New("sdp wrong format,rtpmap before m=")
-				}
-				err := result.Medias[mediaIdx].rtpmap.parse(tmpattr.value)
-				if err != nil {
-					return Rtspsdp{}, errors.New("sdp wrong format,rtpmap parser failed")
-				}
-			case tmpattr.attr == "fmtp":
-				if mediaIdx == -1 {
-					return Rtspsdp{}, errors.New("sdp wrong format,ftmp before m=")
-				}
-				err := result.Medias[mediaIdx].fmtp.parse(tmpattr.value)
-				if err != nil {
-					return Rtspsdp{}, errors.New("sdp wrong format,ftmp parser failed")
-				}
-			default:
-				if mediaIdx == -1 {
-					result.Attrs = append(result.Attrs, tmpattr)
-				} else {
-					result.Medias[mediaIdx].attrs = append(result.Medias[mediaIdx].attrs, tmpattr)
-				}
-
+			media = &result.Medias[len(result.Medias)-1]
+		case 'a':
+			if err := result.parseAttribute(media, value); err != nil {
+				return Rtspsdp{}, err
 			}
 		}
+		//cameras write all sorts of session lines, a malformed one is not fatal
+		if err != nil {
+			packageLogger().Debug("skip sdp line", "line", line, "error", err)
+		}
+	}
+	if result.Direction == "" {
+		result.Direction = DirectionSendRecv
+	}
+	for i := range result.Medias {
+		if result.Medias[i].Direction == "" {
+			result.Medias[i].Direction = result.Direction
+		}
+		result.Medias[i].resolveFormats()
 	}
 	return result, nil
 }
+
+func (s *Rtspsdp) parseAttribute(media *MediaDescription, attrstr string) error {
+	var attr Attribute
+	attr.parse(attrstr)
+	if media == nil {
+		s.Attrs = append(s.Attrs, attr)
+	} else {
+		media.Attributes = append(media.Attributes, attr)
+	}
+	switch {
+	case attr.Key == "control":
+		if media == nil {
+			s.Controlurl = attr.Value
+		} else {
+			media.Control = attr.Value
+		}
+	case attr.Key == "rtpmap":
+		if media == nil {
+			return errors.New("sdp wrong format,rtpmap before m=")
+		}
+		var rtpmap RtpMap
+		if err := rtpmap.parse(attr.Value); err != nil {
+			return errors.New("sdp wrong format,rtpmap parser failed")
+		}
+		media.RtpMaps = append(media.RtpMaps, rtpmap)
+	case attr.Key == "fmtp":
+		if media == nil {
+			return errors.New("sdp wrong format,ftmp before m=")
+		}
+		var fmtp Fmtp
+		if err := fmtp.parse(attr.Value); err != nil {
+			return errors.New("sdp wrong format,ftmp parser failed")
+		}
+		media.Fmtps = append(media.Fmtps, fmtp)
+	case attr.Key == "range":
+		//a range the client does not understand is not an error of the sdp
+		rng, err := ParseRange(attr.Value)
+		if err != nil {
+			break
+		}
+		if media == nil {
+			s.Range, s.HasRange = rng, true
+		} else {
+			media.Range, media.HasRange = rng, true
+		}
+	case attr.Key == "framerate" && media != nil:
+		media.FrameRate, _ = strconv.ParseFloat(strings.TrimSpace(attr.Value), 64)
+	case isDirection(attr.Key):
+		if media == nil {
+			s.Direction = attr.Key
+		} else {
+			media.Direction = attr.Key
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const hikvisionSdp = "v=0\r\n" +
+	"o=- 1109162014219182 1109162014219192 IN IP4 x.y.z.w\r\n" +
+	"s=Media Presentation\r\n" +
+	"e=NONE\r\n" +
+	"b=AS:5050\r\n" +
+	"t=0 0\r\n" +
+	"a=control:rtsp://192.168.1.64:554/Streaming/Channels/101/\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"b=AS:5000\r\n" +
+	"a=recvonly\r\n" +
+	"a=x-dimensions:1920,1080\r\n" +
+	"a=control:rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=1\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 profile-level-id=420029; packetization-mode=1; sprop-parameter-sets=Z00AH5pkAoAt/4C3AQEBQAAA+gAAOpgh,aO48gA==\r\n" +
+	"m=audio 0 RTP/AVP 8\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"b=AS:50\r\n" +
+	"a=recvonly\r\n" +
+	"a=control:rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=2\r\n" +
+	"a=rtpmap:8 PCMA/8000\r\n" +
+	"a=Media_header:MEDIAINFO=494D4B48010200000400000111710110401F000000FA000000000000000000000000000000000000;\r\n" +
+	"a=appversion:1.0\r\n"
+
+const dahuaSdp = "v=0\r\n" +
+	"o=- 2251938202 2251938202 IN IP4 0.0.0.0\r\n" +
+	"s=Media Server\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"t=0 0\r\n" +
+	"a=control:*\r\n" +
+	"a=packetization-supported:DH\r\n" +
+	"a=rtppayload-supported:DH\r\n" +
+	"a=range:npt=now-\r\n" +
+	"m=video 0 RTP/AVP 98\r\n" +
+	"a=control:trackID=0\r\n" +
+	"a=framerate:25.000000\r\n" +
+	"a=rtpmap:98 H265/90000\r\n" +
+	"a=fmtp:98 profile-id=1;sprop-sps=QgEBAWAAAAMAkAAAAwAAAwBdoAKAgC0WWWZJMrwFoCAAAAMAIAAAAwPB;sprop-pps=RAHBcrRiQA==;sprop-vps=QAEMAf//AWAAAAMAkAAAAwAAAwBdlZgJ\r\n" +
+	"a=recvonly\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=control:trackID=1\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/16000\r\n" +
+	"a=fmtp:97 streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1408\r\n" +
+	"a=recvonly\r\n" +
+	"m=application 0 RTP/AVP 107\r\n" +
+	"a=control:trackID=4\r\n" +
+	"a=rtpmap:107 vnd.onvif.metadata/90000\r\n" +
+	"a=recvonly\r\n"
+
+// lf only, as some firmwares send it
+const axisSdp = "v=0\n" +
+	"o=- 12296593456807432286 1 IN IP4 192.168.0.90\n" +
+	"s=Session streamed with GStreamer\n" +
+	"i=rtsp-server\n" +
+	"t=0 0\n" +
+	"a=tool:GStreamer\n" +
+	"a=type:broadcast\n" +
+	"a=range:npt=now-\n" +
+	"a=control:rtsp://192.168.0.90/axis-media/media.amp?videocodec=h264\n" +
+	"m=video 0 RTP/AVP 96\n" +
+	"c=IN IP4 0.0.0.0\n" +
+	"b=AS:50000\n" +
+	"a=rtpmap:96 H264/90000\n" +
+	"a=fmtp:96 packetization-mode=1;profile-level-id=4d001f;sprop-parameter-sets=Z00AH5pkAoAt/4C3AQEBQAAA+gAAOpgh,aO48gA==\n" +
+	"a=ts-refclk:local\n" +
+	"a=mediaclk:sender\n" +
+	"a=recvonly\n" +
+	"a=control:rtsp://192.168.0.90/axis-media/media.amp/stream=0?videocodec=h264\n" +
+	"a=framerate:30.000000\n" +
+	"a=transform:1,0,0;0,1,0;0,0,1\n"
+
+func TestParseMarshalRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		sdp       string
+		encodings []string
+		controls  []string
+	}{
+		{"hikvision", hikvisionSdp, []string{"H264", "PCMA"},
+			[]string{"rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=1", "rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=2"}},
+		{"dahua", dahuaSdp, []string{"H265", "MPEG4-GENERIC", "vnd.onvif.metadata"},
+			[]string{"trackID=0", "trackID=1", "trackID=4"}},
+		{"axis", axisSdp, []string{"H264"},
+			[]string{"rtsp://192.168.0.90/axis-media/media.amp/stream=0?videocodec=h264"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sdp, err := Parse(tt.sdp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sdp.Medias) != len(tt.encodings) {
+				t.Fatalf("got %d medias, want %d", len(sdp.Medias), len(tt.encodings))
+			}
+			for i, media := range sdp.Medias {
+				if media.RtpMap.EncodingName != tt.encodings[i] {
+					t.Errorf("media %d encoding %q, want %q", i, media.RtpMap.EncodingName, tt.encodings[i])
+				}
+				if media.Control != tt.controls[i] {
+					t.Errorf("media %d control %q, want %q", i, media.Control, tt.controls[i])
+				}
+				if media.Direction != DirectionRecvOnly {
+					t.Errorf("media %d direction %q", i, media.Direction)
+				}
+			}
+
+			out := sdp.Marshal()
+			again, err := Parse(string(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sdp, again) {
+				t.Errorf("sdp changed by Marshal\n%+v\n%+v\n%s", sdp, again, out)
+			}
+			if string(again.Marshal()) != string(out) {
+				t.Errorf("Marshal is not stable\n%s\n%s", out, again.Marshal())
+			}
+		})
+	}
+}
+
+func TestParseVendorAttributes(t *testing.T) {
+	sdp, err := Parse(hikvisionSdp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sdp.Controlurl != "rtsp://192.168.1.64:554/Streaming/Channels/101/" {
+		t.Errorf("control %q", sdp.Controlurl)
+	}
+	if v, ok := sdp.Medias[0].Attribute("x-dimensions"); !ok || v != "1920,1080" {
+		t.Errorf("x-dimensions %q %v", v, ok)
+	}
+	if pts := sdp.Medias[1].PayloadTypes(); len(pts) != 1 || pts[0] != 8 {
+		t.Errorf("payload types %v", pts)
+	}
+	//the static payload type keeps its own rtpmap line and no second one is added
+	if out := string(sdp.Marshal()); strings.Count(out, "a=rtpmap:8 ") != 1 {
+		t.Errorf("rtpmap of pt 8 repeated\n%s", out)
+	}
+
+	sdp, err = Parse(dahuaSdp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sdp.Controlurl != "*" || !sdp.HasRange || !sdp.HasConnection {
+		t.Errorf("session %+v", sdp)
+	}
+	if sdp.Medias[0].FrameRate != 25 {
+		t.Errorf("framerate %v", sdp.Medias[0].FrameRate)
+	}
+}
+
+func sdpLines(sdp Rtspsdp) []string {
+	return strings.Split(strings.TrimSuffix(string(sdp.Marshal()), "\r\n"), "\r\n")
+}
+
+func hasLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMediaBuilders(t *testing.T) {
+	sps, _ := base64.StdEncoding.DecodeString("Z00AH5pkAoAt/4C3AQEBQAAA+gAAOpgh")
+	pps, _ := base64.StdEncoding.DecodeString("aO48gA==")
+	aac, err := MakeAACMedia(97, []byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g711a, err := MakeG711Media(G711A)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g711u, err := MakeG711Media(G711U)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = MakeG711Media(H264); err == nil {
+		t.Error("h264 accepted as g711")
+	}
+
+	tests := []struct {
+		name  string
+		media MediaDescription
+		lines []string
+	}{
+		{"h264", MakeH264Media(96, append([]byte{0, 0, 0, 1}, sps...), pps), []string{
+			"m=video 0 RTP/AVP 96",
+			"a=rtpmap:96 H264/90000",
+			"a=fmtp:96 packetization-mode=1;profile-level-id=4D001F;sprop-parameter-sets=Z00AH5pkAoAt/4C3AQEBQAAA+gAAOpgh,aO48gA==",
+		}},
+		{"h265", MakeH265Media(98, []byte{0x40, 0x01}, []byte{0x42, 0x01}, []byte{0x44, 0x01}), []string{
+			"m=video 0 RTP/AVP 98",
+			"a=rtpmap:98 H265/90000",
+			"a=fmtp:98 sprop-vps=QAE=;sprop-sps=QgE=;sprop-pps=RAE=",
+		}},
+		{"aac", aac, []string{
+			"m=audio 0 RTP/AVP 97",
+			"a=rtpmap:97 mpeg4-generic/44100/2",
+			"a=fmtp:97 streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1210",
+		}},
+		{"g711a", g711a, []string{"m=audio 0 RTP/AVP 8", "a=rtpmap:8 PCMA/8000"}},
+		{"g711u", g711u, []string{"m=audio 0 RTP/AVP 0", "a=rtpmap:0 PCMU/8000"}},
+		{"opus stereo", MakeOpusMedia(111, 2), []string{
+			"m=audio 0 RTP/AVP 111",
+			"a=rtpmap:111 opus/48000/2",
+			"a=fmtp:111 stereo=1;sprop-stereo=1",
+		}},
+		{"opus mono", MakeOpusMedia(111, 1), []string{
+			"a=rtpmap:111 opus/48000/2",
+			"a=fmtp:111 sprop-stereo=0",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sdp := MakeSdp("10.0.0.1", tt.media)
+			lines := sdpLines(sdp)
+			for _, line := range append(tt.lines, "a=control:trackID=0", "a=control:*") {
+				if !hasLine(lines, line) {
+					t.Errorf("no line %q in\n%s", line, sdp.Marshal())
+				}
+			}
+			if strings.Count(string(sdp.Marshal()), "a=rtpmap:") != 1 {
+				t.Errorf("want one rtpmap in\n%s", sdp.Marshal())
+			}
+			again, err := Parse(string(sdp.Marshal()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again.Marshal()) != string(sdp.Marshal()) {
+				t.Errorf("round trip differs\n%s\n%s", sdp.Marshal(), again.Marshal())
+			}
+		})
+	}
+}
+
+func TestMakeSdp(t *testing.T) {
+	sdp := MakeSdp("::1", MakeOpusMedia(111, 2), MakeOpusMedia(112, 1))
+	lines := sdpLines(sdp)
+	if lines[0] != "v=0" || !strings.HasSuffix(lines[1], " IN IP6 ::1") || lines[2] != "s=Stream" || lines[3] != "t=0 0" {
+		t.Errorf("session lines %q", lines[:4])
+	}
+	if !hasLine(lines, "a=control:trackID=1") {
+		t.Errorf("second media has no trackID=1\n%s", sdp.Marshal())
+	}
+	//sendrecv is the default of the session and the medias
+	if strings.Contains(string(sdp.Marshal()), "sendrecv") {
+		t.Errorf("sendrecv written\n%s", sdp.Marshal())
+	}
+}
+
+func TestMarshalKeepsAttributes(t *testing.T) {
+	//spare capacity the added lines must not be written into
+	sessionAttrs := make([]Attribute, 1, 4)
+	sessionAttrs[0] = Attribute{Key: "tool", Value: "test"}
+	mediaAttrs := make([]Attribute, 1, 4)
+	mediaAttrs[0] = Attribute{Key: "framerate", Value: "25"}
+	media := MakeH264Media(96, nil, nil)
+	media.Attributes = mediaAttrs
+	media.Control = "trackID=0"
+	sdp := MakeSdp("127.0.0.1", media)
+	sdp.Attrs = sessionAttrs
+	sdp.Controlurl = "*"
+	first := string(sdp.Marshal())
+	if !strings.Contains(first, "a=control:*\r\n") || !strings.Contains(first, "a=control:trackID=0\r\n") {
+		t.Fatalf("controls not written\n%s", first)
+	}
+	if extra := sessionAttrs[:2][1]; extra != (Attribute{}) {
+		t.Errorf("session attributes written into the caller's array: %+v", extra)
+	}
+	if extra := mediaAttrs[:2][1]; extra != (Attribute{}) {
+		t.Errorf("media attributes written into the caller's array: %+v", extra)
+	}
+	if len(sdp.Attrs) != 1 || len(sdp.Medias[0].Attributes) != 1 || string(sdp.Marshal()) != first {
+		t.Errorf("a second Marshal differs\n%s", sdp.Marshal())
+	}
+}
@@ -0,0 +1,358 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marshal writes the session description for a DESCRIBE response or an ANNOUNCE.
+// Attributes are written as they are, Control, RtpMaps, Fmtps, Direction, FrameRate
+// and Range are added when Attributes has no line with their key, or for the payload
+// type of a rtpmap or fmtp. So a parsed sdp is written back unchanged and a built
+// one needs only the fields
+func (s *Rtspsdp) Marshal() []byte {
+	var sdp strings.Builder
+	line := func(t byte, value string) {
+		sdp.WriteByte(t)
+		sdp.WriteByte('=')
+		sdp.WriteString(value)
+		sdp.WriteString("\r\n")
+	}
+	line('v', strconv.Itoa(s.Version))
+	line('o', s.Origin.String())
+	//s= is mandatory, a single space when there is no name
+	if s.SessionName == "" {
+		line('s', " ")
+	} else {
+		line('s', s.SessionName)
+	}
+	if s.Info != "" {
+		line('i', s.Info)
+	}
+	if s.Uri != "" {
+		line('u', s.Uri)
+	}
+	for _, email := range s.Emails {
+		line('e', email)
+	}
+	for _, phone := range s.Phones {
+		line('p', phone)
+	}
+	if s.HasConnection {
+		line('c', s.Connection.String())
+	}
+	for _, bw := range s.Bandwidths {
+		line('b', bw.String())
+	}
+	if len(s.Timings) == 0 {
+		line('t', "0 0")
+	}
+	for _, timing := range s.Timings {
+		line('t', strconv.FormatUint(timing.Start, 10)+" "+strconv.FormatUint(timing.Stop, 10))
+		for _, repeat := range timing.Repeats {
+			line('r', repeat)
+		}
+	}
+	if s.TimeZones != "" {
+		line('z', s.TimeZones)
+	}
+	if s.Key != "" {
+		line('k', s.Key)
+	}
+	for _, attr := range s.sessionAttributes() {
+		line('a', attr.String())
+	}
+	for i := range s.Medias {
+		media := &s.Medias[i]
+		line('m', media.String())
+		if media.Title != "" {
+			line('i', media.Title)
+		}
+		for _, conn := range media.Connections {
+			line('c', conn.String())
+		}
+		for _, bw := range media.Bandwidths {
+			line('b', bw.String())
+		}
+		if media.Key != "" {
+			line('k', media.Key)
+		}
+		for _, attr := range media.attributes(s.direction()) {
+			line('a', attr.String())
+		}
+	}
+	return []byte(sdp.String())
+}
+
+func (s *Rtspsdp) direction() string {
+	if s.Direction == "" {
+		return DirectionSendRecv
+	}
+	return s.Direction
+}
+
+func hasDirection(attrs []Attribute) bool {
+	for _, a := range attrs {
+		if isDirection(a.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Rtspsdp) sessionAttributes() []Attribute {
+	attrs := append([]Attribute(nil), s.Attrs...)
+	add := func(key string, value string) {
+		if _, ok := findAttribute(s.Attrs, key); !ok {
+			attrs = append(attrs, Attribute{Key: key, Value: value})
+		}
+	}
+	if s.Controlurl != "" {
+		add("control", s.Controlurl)
+	}
+	if s.HasRange {
+		add("range", s.Range.String())
+	}
+	//sendrecv is the default and is left out
+	if s.direction() != DirectionSendRecv && !hasDirection(s.Attrs) {
+		attrs = append(attrs, Attribute{Key: s.direction()})
+	}
+	return attrs
+}
+
+func (m *MediaDescription) attributes(sessionDirection string) []Attribute {
+	attrs := append([]Attribute(nil), m.Attributes...)
+	add := func(key string, value string) {
+		if _, ok := findAttribute(m.Attributes, key); !ok {
+			attrs = append(attrs, Attribute{Key: key, Value: value})
+		}
+	}
+	rtpmaps, fmtps := m.RtpMaps, m.Fmtps
+	if len(rtpmaps) == 0 && m.RtpMap.EncodingName != "" {
+		rtpmaps = []RtpMap{m.RtpMap}
+	}
+	if len(fmtps) == 0 && m.Fmtp.Params != "" {
+		fmtps = []Fmtp{m.Fmtp}
+	}
+	for _, rtpmap := range rtpmaps {
+		if !rtpmap.static && !hasFormatAttribute(m.Attributes, "rtpmap", rtpmap.PayloadType) {
+			attrs = append(attrs, Attribute{Key: "rtpmap", Value: rtpmap.String()})
+		}
+	}
+	for _, fmtp := range fmtps {
+		if !hasFormatAttribute(m.Attributes, "fmtp", fmtp.Format) {
+			attrs = append(attrs, Attribute{Key: "fmtp", Value: fmtp.String()})
+		}
+	}
+	if m.FrameRate > 0 {
+		add("framerate", strconv.FormatFloat(m.FrameRate, 'f', -1, 64))
+	}
+	if m.HasRange {
+		add("range", m.Range.String())
+	}
+	if m.Direction != "" && m.Direction != sessionDirection && !hasDirection(m.Attributes) {
+		attrs = append(attrs, Attribute{Key: m.Direction})
+	}
+	if m.Control != "" {
+		add("control", m.Control)
+	}
+	return attrs
+}
+
+// hasFormatAttribute looks for a=<key>:<pt> ...
+func hasFormatAttribute(attrs []Attribute, key string, pt int) bool {
+	prefix := strconv.Itoa(pt) + " "
+	for _, a := range attrs {
+		if a.Key == key && strings.HasPrefix(strings.TrimSpace(a.Value)+" ", prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a Attribute) String() string {
+	if a.Value == "" {
+		return a.Key
+	}
+	return a.Key + ":" + a.Value
+}
+
+func (f Fmtp) String() string {
+	return strconv.Itoa(f.Format) + " " + f.Params
+}
+
+func (r RtpMap) String() string {
+	rtpmap := strconv.Itoa(r.PayloadType) + " " + r.EncodingName + "/" + strconv.Itoa(r.ClockRate)
+	if r.EncodingParams != "" {
+		rtpmap += "/" + r.EncodingParams
+	}
+	return rtpmap
+}
+
+// String fills the empty fields, o= is mandatory
+func (o Origin) String() string {
+	field := func(value string, def string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	}
+	return field(o.Username, "-") + " " + field(o.SessionID, "0") + " " + field(o.SessionVersion, "0") + " " +
+		field(o.NetType, "IN") + " " + field(o.AddrType, "IP4") + " " + field(o.Address, "0.0.0.0")
+}
+
+func (c Connection) String() string {
+	conn := c.NetType + " " + c.AddrType + " " + c.Address
+	if c.TTL > 0 {
+		conn += "/" + strconv.Itoa(c.TTL)
+	}
+	if c.NumAddrs > 0 {
+		conn += "/" + strconv.Itoa(c.NumAddrs)
+	}
+	return conn
+}
+
+func (b Bandwidth) String() string {
+	return b.Type + ":" + strconv.Itoa(b.Value)
+}
+
+// String is the value of the m= line
+func (m MediaDescription) String() string {
+	port := strconv.Itoa(m.Port)
+	if m.NumPorts > 0 {
+		port += "/" + strconv.Itoa(m.NumPorts)
+	}
+	proto := m.Proto
+	if proto == "" {
+		proto = "RTP/AVP"
+	}
+	return m.Media + " " + port + " " + proto + " " + strings.Join(m.Formats, " ")
+}
+
+// MakeSdp builds a session description of the medias, the ones without control
+// get trackID=<index>
+func MakeSdp(address string, medias ...MediaDescription) Rtspsdp {
+	version := strconv.FormatInt(time.Now().Unix(), 10)
+	sdp := Rtspsdp{
+		Origin: Origin{
+			Username:       "-",
+			SessionID:      version,
+			SessionVersion: version,
+			NetType:        "IN",
+			AddrType:       "IP4",
+			Address:        address,
+		},
+		SessionName: "Stream",
+		Timings:     []Timing{{}},
+		Controlurl:  "*",
+		Direction:   DirectionSendRecv,
+	}
+	if strings.Contains(address, ":") {
+		sdp.Origin.AddrType = "IP6"
+	}
+	for i, media := range medias {
+		if media.Control == "" {
+			media.Control = "trackID=" + strconv.Itoa(i)
+		}
+		if media.Direction == "" {
+			media.Direction = sdp.Direction
+		}
+		sdp.Medias = append(sdp.Medias, media)
+	}
+	return sdp
+}
+
+func makeRtpMedia(media string, pt int, rtpmap RtpMap, params string) MediaDescription {
+	rtpmap.PayloadType = pt
+	m := MediaDescription{
+		Media:   media,
+		Proto:   "RTP/AVP",
+		Formats: []string{strconv.Itoa(pt)},
+		RtpMaps: []RtpMap{rtpmap},
+		RtpMap:  rtpmap,
+	}
+	if params != "" {
+		m.Fmtp = Fmtp{Format: pt, Params: params}
+		m.Fmtps = []Fmtp{m.Fmtp}
+	}
+	return m
+}
+
+// trimStartCode accepts parameter sets with or without an annexb start code
+func trimStartCode(nalu []byte) []byte {
+	if len(nalu) >= 4 && nalu[0] == 0 && nalu[1] == 0 && nalu[2] == 0 && nalu[3] == 1 {
+		return nalu[4:]
+	}
+	if len(nalu) >= 3 && nalu[0] == 0 && nalu[1] == 0 && nalu[2] == 1 {
+		return nalu[3:]
+	}
+	return nalu
+}
+
+// MakeH264Media is rfc6184 with packetization-mode 1
+func MakeH264Media(pt int, sps []byte, pps []byte) MediaDescription {
+	sps = trimStartCode(sps)
+	pps = trimStartCode(pps)
+	params := []string{"packetization-mode=1"}
+	if len(sps) >= 4 {
+		params = append(params, "profile-level-id="+strings.ToUpper(hex.EncodeToString(sps[1:4])))
+	}
+	if len(sps) > 0 && len(pps) > 0 {
+		params = append(params, "sprop-parameter-sets="+base64.StdEncoding.EncodeToString(sps)+","+base64.StdEncoding.EncodeToString(pps))
+	}
+	return makeRtpMedia("video", pt, RtpMap{EncodingName: "H264", ClockRate: 90000}, strings.Join(params, ";"))
+}
+
+// MakeH265Media is rfc7798
+func MakeH265Media(pt int, vps []byte, sps []byte, pps []byte) MediaDescription {
+	var params []string
+	sets := []struct {
+		name string
+		nalu []byte
+	}{{"sprop-vps", vps}, {"sprop-sps", sps}, {"sprop-pps", pps}}
+	for _, set := range sets {
+		if nalu := trimStartCode(set.nalu); len(nalu) > 0 {
+			params = append(params, set.name+"="+base64.StdEncoding.EncodeToString(nalu))
+		}
+	}
+	return makeRtpMedia("video", pt, RtpMap{EncodingName: "H265", ClockRate: 90000}, strings.Join(params, ";"))
+}
+
+// MakeAACMedia is rfc3640 AAC-hbr, config is the AudioSpecificConfig
+func MakeAACMedia(pt int, config []byte) (MediaDescription, error) {
+	sampleRate, channels, err := parseAacConfig(config)
+	if err != nil {
+		return MediaDescription{}, err
+	}
+	params := "streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=" + hex.EncodeToString(config)
+	rtpmap := RtpMap{EncodingName: "mpeg4-generic", ClockRate: sampleRate}
+	if channels > 0 {
+		rtpmap.EncodingParams = strconv.Itoa(channels)
+	}
+	return makeRtpMedia("audio", pt, rtpmap, params), nil
+}
+
+// MakeG711Media uses the static payload types of rfc3551, 8 for G711A and 0 for G711U
+func MakeG711Media(cid Codec) (MediaDescription, error) {
+	switch cid {
+	case G711A:
+		return makeRtpMedia("audio", 8, RtpMap{EncodingName: "PCMA", ClockRate: 8000}, ""), nil
+	case G711U:
+		return makeRtpMedia("audio", 0, RtpMap{EncodingName: "PCMU", ClockRate: 8000}, ""), nil
+	}
+	return MediaDescription{}, errors.New("codec is not g711")
+}
+
+// MakeOpusMedia is rfc7587, the rtpmap is always opus/48000/2 and stereo is
+// signalled by the fmtp
+func MakeOpusMedia(pt int, channels int) MediaDescription {
+	params := "sprop-stereo=0"
+	if channels == 2 {
+		params = "stereo=1;sprop-stereo=1"
+	}
+	return makeRtpMedia("audio", pt, RtpMap{EncodingName: "opus", ClockRate: 48000, EncodingParams: "2"}, params)
+}
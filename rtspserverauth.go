@@ -0,0 +1,397 @@
+package rtsp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Permission int
+
+const (
+	PermissionRead    Permission = 1 << iota //DESCRIBE, SETUP, PLAY
+	PermissionPublish                        //ANNOUNCE, RECORD, SETUP with mode=record
+)
+
+var (
+	ErrNoCredentials  = errors.New("request has no credentials")
+	ErrBadCredentials = errors.New("wrong username or password")
+	ErrStaleNonce     = errors.New("digest nonce is stale")
+	ErrForbidden      = errors.New("user has no permission on path")
+)
+
+// MethodPermission returns the permission needed by req, zero means the
+// request is allowed without authentication
+func MethodPermission(req *Request) Permission {
+	switch req.Method {
+	case "DESCRIBE", "PLAY":
+		return PermissionRead
+	case "ANNOUNCE", "RECORD":
+		return PermissionPublish
+	case "SETUP":
+		transports, _ := ParseTransports(req.Header.Get("Transport"))
+		for _, transport := range transports {
+			if transport.Mode == "RECORD" {
+				return PermissionPublish
+			}
+		}
+		return PermissionRead
+	}
+	return 0
+}
+
+// UserStore provides the credentials and the per-path permissions of users
+type UserStore interface {
+	Password(username string) (string, bool)
+	Allowed(username string, path string, perm Permission) bool
+}
+
+type memoryUser struct {
+	password string
+	paths    map[string]Permission
+}
+
+// MemoryUserStore keeps users in memory, a permission granted on a path
+// also covers everything below it, "/" covers all paths
+type MemoryUserStore struct {
+	mtx   sync.RWMutex
+	users map[string]*memoryUser
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[string]*memoryUser)}
+}
+
+func (s *MemoryUserStore) AddUser(username string, password string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if user, ok := s.users[username]; ok {
+		user.password = password
+		return
+	}
+	s.users[username] = &memoryUser{password: password, paths: make(map[string]Permission)}
+}
+
+func (s *MemoryUserStore) RemoveUser(username string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.users, username)
+}
+
+func (s *MemoryUserStore) Grant(username string, path string, perm Permission) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if user, ok := s.users[username]; ok {
+		user.paths[cleanPath(path)] |= perm
+	}
+}
+
+func (s *MemoryUserStore) Password(username string) (string, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	user, ok := s.users[username]
+	if !ok {
+		return "", false
+	}
+	return user.password, true
+}
+
+func (s *MemoryUserStore) Allowed(username string, path string, perm Permission) bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	user, ok := s.users[username]
+	if !ok {
+		return false
+	}
+	path = cleanPath(path)
+	for {
+		if user.paths[path]&perm == perm {
+			return true
+		}
+		if path == "/" {
+			return false
+		}
+		idx := strings.LastIndexByte(path, '/')
+		if idx <= 0 {
+			path = "/"
+		} else {
+			path = path[:idx]
+		}
+	}
+}
+
+func cleanPath(path string) string {
+	path = "/" + strings.Trim(path, "/")
+	return path
+}
+
+// requestPath is the cleaned path of the request url, a SETUP url keeps its track
+// part, Allowed grants it by the permission of the parent path
+func requestPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return cleanPath(uri)
+	}
+	return cleanPath(u.Path)
+}
+
+// Authenticator verifies the Authorization header of a request for a server
+type Authenticator interface {
+	//Authenticate returns the user who sent req
+	Authenticate(req *Request) (string, error)
+	//Challenge is the WWW-Authenticate value sent with a 401
+	Challenge(stale bool) string
+}
+
+type BasicAuthenticator struct {
+	Realm string
+	Users UserStore
+}
+
+func NewBasicAuthenticator(realm string, users UserStore) *BasicAuthenticator {
+	return &BasicAuthenticator{Realm: realm, Users: users}
+}
+
+func (a *BasicAuthenticator) Challenge(stale bool) string {
+	return "Basic realm=" + quoteString(a.Realm)
+}
+
+func (a *BasicAuthenticator) Authenticate(req *Request) (string, error) {
+	authorization := strings.TrimSpace(req.Header.Get("Authorization"))
+	if len(authorization) < 6 || !strings.EqualFold(authorization[:6], "Basic ") {
+		return "", ErrNoCredentials
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(authorization[6:]))
+	if err != nil {
+		return "", ErrBadCredentials
+	}
+	userpass := strings.SplitN(string(decoded), ":", 2)
+	if len(userpass) != 2 {
+		return "", ErrBadCredentials
+	}
+	password, ok := a.Users.Password(userpass[0])
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(userpass[1])) != 1 {
+		return "", ErrBadCredentials
+	}
+	return userpass[0], nil
+}
+
+// at most that many nonces have their nc tracked
+const maxNonces = 4096
+
+type nonceState struct {
+	expire time.Time
+	nc     uint32
+}
+
+// DigestAuthenticator issues nonces signed by a random key, a nonce expires
+// after NonceTTL and every nc can be used only once
+type DigestAuthenticator struct {
+	Realm     string
+	Algorithm string //MD5 if empty, also MD5-sess, SHA-256, SHA-256-sess, SHA-512-256...
+	NonceTTL  time.Duration
+	Users     UserStore
+	key       []byte
+	mtx       sync.Mutex
+	nonces    map[string]*nonceState //only the nonces of authenticated requests
+}
+
+func NewDigestAuthenticator(realm string, users UserStore) *DigestAuthenticator {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &DigestAuthenticator{
+		Realm:    realm,
+		NonceTTL: time.Minute * 5,
+		Users:    users,
+		key:      key,
+		nonces:   make(map[string]*nonceState),
+	}
+}
+
+// nonce = base64(timestamp random hmac(timestamp random)), nothing is stored
+// until a request is authenticated with it
+func (a *DigestAuthenticator) newNonce() string {
+	buf := make([]byte, 16, 32)
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	rand.Read(buf[8:16])
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(buf)
+	buf = append(buf, mac.Sum(nil)[:16]...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// nonceExpire checks the signature and returns when the nonce expires
+func (a *DigestAuthenticator) nonceExpire(nonce string) (time.Time, bool) {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buf) != 32 {
+		return time.Time{}, false
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(buf[:16])
+	if !hmac.Equal(mac.Sum(nil)[:16], buf[16:]) {
+		return time.Time{}, false
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(buf)))
+	return issued.Add(a.NonceTTL), true
+}
+
+func (a *DigestAuthenticator) Challenge(stale bool) string {
+	challenge := "Digest realm=" + quoteString(a.Realm) + ", nonce=\"" + a.newNonce() + "\", qop=\"auth\""
+	if a.Algorithm != "" {
+		challenge += ", algorithm=" + a.Algorithm
+	}
+	if stale {
+		challenge += ", stale=true"
+	}
+	return challenge
+}
+
+// checkNonce rejects forged and expired nonces and replayed nc values
+func (a *DigestAuthenticator) checkNonce(nonce string, nc uint32) error {
+	expire, ok := a.nonceExpire(nonce)
+	if !ok {
+		return ErrBadCredentials
+	}
+	now := time.Now()
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if now.After(expire) {
+		delete(a.nonces, nonce)
+		return ErrStaleNonce
+	}
+	state, ok := a.nonces[nonce]
+	if !ok {
+		if len(a.nonces) >= maxNonces {
+			for n, state := range a.nonces {
+				if now.After(state.expire) {
+					delete(a.nonces, n)
+				}
+			}
+		}
+		//still full, the client starts again with a fresh nonce
+		if len(a.nonces) >= maxNonces {
+			return ErrStaleNonce
+		}
+		state = &nonceState{expire: expire}
+		a.nonces[nonce] = state
+	}
+	if nc <= state.nc {
+		return ErrBadCredentials
+	}
+	state.nc = nc
+	return nil
+}
+
+func (a *DigestAuthenticator) Authenticate(req *Request) (string, error) {
+	authorization := strings.TrimSpace(req.Header.Get("Authorization"))
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Digest ") {
+		return "", ErrNoCredentials
+	}
+	params, err := parseAuthParams(authorization[7:])
+	if err != nil {
+		return "", ErrBadCredentials
+	}
+	username := params["username"]
+	if params["realm"] != a.Realm || params["uri"] != req.Uri || params["response"] == "" {
+		return "", ErrBadCredentials
+	}
+	if !strings.EqualFold(params["algorithm"], a.Algorithm) &&
+		!(a.Algorithm == "" && strings.EqualFold(params["algorithm"], "MD5")) {
+		return "", ErrBadCredentials
+	}
+	password, ok := a.Users.Password(username)
+	if !ok {
+		return "", ErrBadCredentials
+	}
+
+	digest := DigestAuthenticate{
+		realm:     a.Realm,
+		nonce:     params["nonce"],
+		algorithm: params["algorithm"],
+		qop:       params["qop"],
+		cnonce:    params["cnonce"],
+		username:  username,
+		password:  password,
+		uri:       params["uri"],
+		method:    req.Method,
+	}
+	//qop=auth is the only one offered, without it nothing stops a replay
+	if digest.qop != "auth" || digest.cnonce == "" {
+		return "", ErrBadCredentials
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 32)
+	if err != nil {
+		return "", ErrBadCredentials
+	}
+	digest.nc = uint32(nc)
+	response := digest.authenticateInfo()
+	if subtle.ConstantTimeCompare([]byte(response), []byte(strings.ToLower(params["response"]))) != 1 {
+		return "", ErrBadCredentials
+	}
+	//only a correct response may consume the nonce count
+	if err := a.checkNonce(digest.nonce, digest.nc); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// ServerAuth protects the mounts of a server: requests which need a permission
+// are authenticated by one of Authenticators and checked against Users
+type ServerAuth struct {
+	Authenticators []Authenticator
+	Users          UserStore
+}
+
+// Check returns nil if req may be served, otherwise the 401 or 403 response to send
+func (a *ServerAuth) Check(req *Request) (string, *Response) {
+	perm := MethodPermission(req)
+	if perm == 0 {
+		return "", nil
+	}
+	var username string
+	err := ErrNoCredentials
+	for _, authenticator := range a.Authenticators {
+		username, err = authenticator.Authenticate(req)
+		if err != ErrNoCredentials {
+			break
+		}
+	}
+	if err != nil {
+		return "", a.unauthorized(req, err == ErrStaleNonce)
+	}
+	if !a.Users.Allowed(username, requestPath(req.Uri), perm) {
+		res := makeAuthResponse(req, "403", "Forbidden")
+		return username, &res
+	}
+	return username, nil
+}
+
+func (a *ServerAuth) unauthorized(req *Request, stale bool) *Response {
+	res := makeAuthResponse(req, "401", "Unauthorized")
+	for _, authenticator := range a.Authenticators {
+		res.Header.Add("WWW-Authenticate", authenticator.Challenge(stale))
+	}
+	return &res
+}
+
+func makeAuthResponse(req *Request, code string, reason string) Response {
+	var res Response
+	res.Version = req.Version
+	if res.Version == "" {
+		res.Version = RTSP10
+	}
+	res.StatusCode = code
+	res.Reason = reason
+	res.Header.Set("CSeq", req.Header.Get("CSeq"))
+	res.Header.Set("Content-Length", "0")
+	return res
+}
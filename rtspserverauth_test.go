@@ -0,0 +1,194 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAuthUrl = "rtsp://127.0.0.1/live/cam1"
+
+func newTestUsers() *MemoryUserStore {
+	users := NewMemoryUserStore()
+	users.AddUser("alice", "secret")
+	users.Grant("alice", "/live", PermissionRead)
+	users.AddUser("bob", "hunter2")
+	return users
+}
+
+// digestClient answers a challenge of a the way Rtspclient does
+func digestClient(t *testing.T, a *DigestAuthenticator, password string) *DigestAuthenticate {
+	t.Helper()
+	challenge := a.Challenge(false)
+	auth := &DigestAuthenticate{username: "alice", password: password}
+	if err := auth.parse(strings.TrimPrefix(challenge, "Digest ")); err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+func makeAuthRequest(method string, authorization string) *Request {
+	req := &Request{Method: method, Uri: testAuthUrl, Version: RTSP10}
+	req.Header.Set("CSeq", "2")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return req
+}
+
+func TestDigestAuthenticator(t *testing.T) {
+	for _, algorithm := range []string{"", "MD5-sess", "SHA-256", "SHA-512-256-sess"} {
+		a := NewDigestAuthenticator("cams", newTestUsers())
+		a.Algorithm = algorithm
+		auth := digestClient(t, a, "secret")
+		for i := 0; i < 3; i++ {
+			user, err := a.Authenticate(makeAuthRequest("DESCRIBE", auth.authorization("DESCRIBE", testAuthUrl)))
+			if err != nil || user != "alice" {
+				t.Errorf("algorithm %q request %d: %q %v", algorithm, i, user, err)
+			}
+		}
+	}
+}
+
+func TestDigestAuthenticatorRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		//returns the Authorization header
+		authorization func(a *DigestAuthenticator) string
+		err           error
+	}{
+		{"no credentials", func(a *DigestAuthenticator) string { return "" }, ErrNoCredentials},
+		{"basic", func(a *DigestAuthenticator) string { return "Basic YWxpY2U6c2VjcmV0" }, ErrNoCredentials},
+		{"wrong password", func(a *DigestAuthenticator) string {
+			return digestClient(t, a, "guess").authorization("DESCRIBE", testAuthUrl)
+		}, ErrBadCredentials},
+		{"other uri", func(a *DigestAuthenticator) string {
+			return digestClient(t, a, "secret").authorization("DESCRIBE", testAuthUrl+"/trackID=1")
+		}, ErrBadCredentials},
+		{"replayed nc", func(a *DigestAuthenticator) string {
+			authorization := digestClient(t, a, "secret").authorization("DESCRIBE", testAuthUrl)
+			if _, err := a.Authenticate(makeAuthRequest("DESCRIBE", authorization)); err != nil {
+				t.Fatal(err)
+			}
+			return authorization
+		}, ErrBadCredentials},
+		{"lower nc", func(a *DigestAuthenticator) string {
+			auth := digestClient(t, a, "secret")
+			first := auth.authorization("DESCRIBE", testAuthUrl)
+			if _, err := a.Authenticate(makeAuthRequest("DESCRIBE", auth.authorization("DESCRIBE", testAuthUrl))); err != nil {
+				t.Fatal(err)
+			}
+			return first
+		}, ErrBadCredentials},
+		{"missing qop", func(a *DigestAuthenticator) string {
+			//an rfc2069 response is correct but carries no nc
+			auth := digestClient(t, a, "secret")
+			auth.qop = ""
+			return auth.authorization("DESCRIBE", testAuthUrl)
+		}, ErrBadCredentials},
+		{"forged nonce", func(a *DigestAuthenticator) string {
+			auth := digestClient(t, a, "secret")
+			buf, _ := base64.RawURLEncoding.DecodeString(auth.nonce)
+			buf[31] ^= 1
+			auth.nonce = base64.RawURLEncoding.EncodeToString(buf)
+			return auth.authorization("DESCRIBE", testAuthUrl)
+		}, ErrBadCredentials},
+		{"nonce of another server", func(a *DigestAuthenticator) string {
+			other := NewDigestAuthenticator("cams", newTestUsers())
+			return digestClient(t, other, "secret").authorization("DESCRIBE", testAuthUrl)
+		}, ErrBadCredentials},
+		{"expired nonce", func(a *DigestAuthenticator) string {
+			a.NonceTTL = -time.Second
+			return digestClient(t, a, "secret").authorization("DESCRIBE", testAuthUrl)
+		}, ErrStaleNonce},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewDigestAuthenticator("cams", newTestUsers())
+			user, err := a.Authenticate(makeAuthRequest("DESCRIBE", tt.authorization(a)))
+			if err != tt.err {
+				t.Errorf("got %q %v, want %v", user, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	a := NewBasicAuthenticator("cams", newTestUsers())
+	tests := []struct {
+		authorization string
+		user          string
+		err           error
+	}{
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret")), "alice", nil},
+		{"basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret")), "alice", nil},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("alice:guess")), "", ErrBadCredentials},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("alice")), "", ErrBadCredentials},
+		{"Basic %%%", "", ErrBadCredentials},
+		{"", "", ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		user, err := a.Authenticate(makeAuthRequest("DESCRIBE", tt.authorization))
+		if user != tt.user || err != tt.err {
+			t.Errorf("%q: got %q %v, want %q %v", tt.authorization, user, err, tt.user, tt.err)
+		}
+	}
+}
+
+func TestServerAuthCheck(t *testing.T) {
+	users := newTestUsers()
+	digest := NewDigestAuthenticator("cams", users)
+	auth := &ServerAuth{Authenticators: []Authenticator{NewBasicAuthenticator("cams", users), digest}, Users: users}
+	basic := func(user string, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+
+	if _, res := auth.Check(makeAuthRequest("OPTIONS", "")); res != nil {
+		t.Errorf("OPTIONS needs no credentials, got %s", res.StatusCode)
+	}
+	_, res := auth.Check(makeAuthRequest("DESCRIBE", ""))
+	if res == nil || res.StatusCode != "401" || len(res.Header.Values("WWW-Authenticate")) != 2 {
+		t.Fatalf("DESCRIBE without credentials got %+v", res)
+	}
+	if user, res := auth.Check(makeAuthRequest("DESCRIBE", basic("alice", "secret"))); res != nil || user != "alice" {
+		t.Errorf("alice got %q %+v", user, res)
+	}
+	if _, res := auth.Check(makeAuthRequest("DESCRIBE", basic("bob", "hunter2"))); res == nil || res.StatusCode != "403" {
+		t.Errorf("bob has no permission, got %+v", res)
+	}
+	if _, res := auth.Check(makeAuthRequest("ANNOUNCE", basic("alice", "secret"))); res == nil || res.StatusCode != "403" {
+		t.Errorf("alice may not publish, got %+v", res)
+	}
+
+	digest.NonceTTL = -time.Second
+	authorization := digestClient(t, digest, "secret").authorization("DESCRIBE", testAuthUrl)
+	_, res = auth.Check(makeAuthRequest("DESCRIBE", authorization))
+	if res == nil || res.StatusCode != "401" || !strings.Contains(strings.Join(res.Header.Values("WWW-Authenticate"), " "), "stale=true") {
+		t.Errorf("expired nonce got %+v", res)
+	}
+}
+
+func TestMemoryUserStoreAllowed(t *testing.T) {
+	users := newTestUsers()
+	users.Grant("bob", "/", PermissionPublish)
+	tests := []struct {
+		user string
+		path string
+		perm Permission
+		want bool
+	}{
+		{"alice", "/live", PermissionRead, true},
+		{"alice", "/live/cam1/trackID=1", PermissionRead, true},
+		{"alice", "/liveness", PermissionRead, false},
+		{"alice", "/live", PermissionPublish, false},
+		{"bob", "/any/path", PermissionPublish, true},
+		{"bob", "/any/path", PermissionRead, false},
+		{"carol", "/live", PermissionRead, false},
+	}
+	for _, tt := range tests {
+		if got := users.Allowed(tt.user, tt.path, tt.perm); got != tt.want {
+			t.Errorf("%s %s %d: got %v", tt.user, tt.path, tt.perm, got)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package rtsp
+
+import (
+	"errors"
+)
+
+// VideoInfo is read from the sequence parameter set, and the video parameter
+// set of H265 for the frame rate when the sps has no timing info
+type VideoInfo struct {
+	Codec        Codec
+	Width        int //cropped
+	Height       int
+	Profile      int     //profile_idc, general_profile_idc for H265
+	Level        int     //level_idc, general_level_idc for H265
+	ChromaFormat int     //0 monochrome, 1 4:2:0, 2 4:2:2, 3 4:4:4
+	BitDepth     int     //of luma
+	FrameRate    float64 //nominal, from the vui timing info, zero if absent
+}
+
+var errShortSps = errors.New("parameter set is too short")
+
+// removeEmulationPrevention drops the 0x03 of every 0x000003 of a nal unit
+func removeEmulationPrevention(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, b := range nalu {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
+
+// bitReader reads a rbsp, reading past the end sets err
+type bitReader struct {
+	data []byte
+	pos  int //in bits
+	err  error
+}
+
+func (r *bitReader) u(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.err = errShortSps
+			return 0
+		}
+		bit := r.data[r.pos/8] >> (7 - uint(r.pos%8)) & 1
+		v = v<<1 | uint32(bit)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) flag() bool {
+	return r.u(1) == 1
+}
+
+func (r *bitReader) skip(n int) {
+	for ; n > 32; n -= 32 {
+		r.u(32)
+	}
+	r.u(n)
+}
+
+// ue is unsigned Exp-Golomb, h264 9.1
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for !r.flag() {
+		if r.err != nil || zeros > 31 {
+			r.err = errShortSps
+			return 0
+		}
+		zeros++
+	}
+	return 1<<uint(zeros) - 1 + r.u(zeros)
+}
+
+// se is signed Exp-Golomb, h264 9.1.1
+func (r *bitReader) se() int32 {
+	k := r.ue()
+	if k%2 == 1 {
+		return int32(k/2 + 1)
+	}
+	return -int32(k / 2)
+}
+
+// subsampling of the chroma, h264 table 6-1 and h265 table 6-1
+func chromaSubsampling(chromaFormat int, separatePlanes bool) (int, int) {
+	if separatePlanes {
+		return 1, 1
+	}
+	switch chromaFormat {
+	case 1:
+		return 2, 2
+	case 2:
+		return 2, 1
+	}
+	return 1, 1
+}
+
+// h264 7.3.2.1.1.1
+func skipH264ScalingList(r *bitReader, size int) {
+	last, next := int32(8), int32(8)
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// ParseH264SPS reads a sps nal unit, h264 7.3.2.1.1, with or without start code
+func ParseH264SPS(sps []byte) (VideoInfo, error) {
+	sps = trimStartCode(sps)
+	if len(sps) < 4 || sps[0]&0x1F != 7 {
+		return VideoInfo{}, errors.New("not a h264 sps")
+	}
+	r := &bitReader{data: removeEmulationPrevention(sps[1:])}
+	info := VideoInfo{Codec: H264, ChromaFormat: 1, BitDepth: 8}
+	info.Profile = int(r.u(8))
+	r.skip(8) //constraint flags
+	info.Level = int(r.u(8))
+	r.ue() //seq_parameter_set_id
+	separatePlanes := false
+	switch info.Profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		info.ChromaFormat = int(r.ue())
+		if info.ChromaFormat == 3 {
+			separatePlanes = r.flag()
+		}
+		info.BitDepth = int(r.ue()) + 8
+		r.ue() //bit_depth_chroma_minus8
+		r.skip(1)
+		if r.flag() {
+			lists := 8
+			if info.ChromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if !r.flag() {
+					continue
+				}
+				if i < 6 {
+					skipH264ScalingList(r, 16)
+				} else {
+					skipH264ScalingList(r, 64)
+				}
+			}
+		}
+	}
+	r.ue() //log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() //log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.skip(1)
+		r.se()
+		r.se()
+		cycle := r.ue()
+		for i := uint32(0); i < cycle && r.err == nil; i++ {
+			r.se()
+		}
+	}
+	r.ue() //max_num_ref_frames
+	r.skip(1)
+	widthMbs := int(r.ue()) + 1
+	heightMapUnits := int(r.ue()) + 1
+	frameMbsOnly := r.flag()
+	if !frameMbsOnly {
+		r.skip(1)
+	}
+	r.skip(1) //direct_8x8_inference_flag
+	fields := 2
+	if frameMbsOnly {
+		fields = 1
+	}
+	info.Width = widthMbs * 16
+	info.Height = fields * heightMapUnits * 16
+	if r.flag() {
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		cropX, cropY := 1, fields
+		if info.ChromaFormat != 0 && !separatePlanes {
+			subWidth, subHeight := chromaSubsampling(info.ChromaFormat, false)
+			cropX, cropY = subWidth, subHeight*fields
+		}
+		info.Width -= cropX * (left + right)
+		info.Height -= cropY * (top + bottom)
+	}
+	if r.err != nil {
+		return VideoInfo{}, r.err
+	}
+	if r.flag() {
+		info.FrameRate = parseH264VuiTiming(r)
+	}
+	return info, nil
+}
+
+// h264 E.1.1 up to the timing info, zero if absent or cut
+func parseH264VuiTiming(r *bitReader) float64 {
+	if r.flag() { //aspect_ratio_info_present_flag
+		if r.u(8) == 255 {
+			r.skip(32)
+		}
+	}
+	if r.flag() { //overscan_info_present_flag
+		r.skip(1)
+	}
+	if r.flag() { //video_signal_type_present_flag
+		r.skip(4)
+		if r.flag() {
+			r.skip(24)
+		}
+	}
+	if r.flag() { //chroma_loc_info_present_flag
+		r.ue()
+		r.ue()
+	}
+	if !r.flag() {
+		return 0
+	}
+	units := r.u(32)
+	scale := r.u(32)
+	if r.err != nil || units == 0 {
+		return 0
+	}
+	//a frame is two fields
+	return float64(scale) / float64(2*units)
+}
+
+// h265 7.3.3, returns general_profile_idc and general_level_idc
+func parseH265ProfileTierLevel(r *bitReader, maxSubLayersMinus1 int) (int, int) {
+	r.skip(3) //general_profile_space, general_tier_flag
+	profile := int(r.u(5))
+	r.skip(32) //general_profile_compatibility_flag
+	r.skip(48) //progressive, interlaced, non packed, frame only and reserved
+	level := int(r.u(8))
+	profilePresent := make([]bool, maxSubLayersMinus1)
+	levelPresent := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		profilePresent[i] = r.flag()
+		levelPresent[i] = r.flag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		for i := maxSubLayersMinus1; i < 8; i++ {
+			r.skip(2)
+		}
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] {
+			r.skip(88)
+		}
+		if levelPresent[i] {
+			r.skip(8)
+		}
+	}
+	return profile, level
+}
+
+// h265 7.3.4
+func skipH265ScalingListData(r *bitReader) {
+	for sizeId := 0; sizeId < 4; sizeId++ {
+		step := 1
+		if sizeId == 3 {
+			step = 3
+		}
+		for matrixId := 0; matrixId < 6; matrixId += step {
+			if !r.flag() {
+				r.ue() //scaling_list_pred_matrix_id_delta
+				continue
+			}
+			coefs := 1 << uint(4+sizeId<<1)
+			if coefs > 64 {
+				coefs = 64
+			}
+			if sizeId > 1 {
+				r.se()
+			}
+			for i := 0; i < coefs && r.err == nil; i++ {
+				r.se()
+			}
+		}
+	}
+}
+
+// h265 7.3.7 in a sps, returns NumDeltaPocs of the set
+func skipH265ShortTermRefPicSet(r *bitReader, idx int, numDeltaPocs []int) int {
+	if idx != 0 && r.flag() { //inter_ref_pic_set_prediction_flag
+		r.skip(1) //delta_rps_sign
+		r.ue()    //abs_delta_rps_minus1
+		n := 0
+		for j := 0; j <= numDeltaPocs[idx-1] && r.err == nil; j++ {
+			used := r.flag()
+			useDelta := true
+			if !used {
+				useDelta = r.flag()
+			}
+			if used || useDelta {
+				n++
+			}
+		}
+		return n
+	}
+	negative := int(r.ue())
+	positive := int(r.ue())
+	if negative > 16 || positive > 16 {
+		r.err = errors.New("wrong short term ref pic set")
+		return 0
+	}
+	for i := 0; i < negative+positive && r.err == nil; i++ {
+		r.ue()
+		r.skip(1)
+	}
+	return negative + positive
+}
+
+// ParseH265SPS reads a sps nal unit, h265 7.3.2.2, with or without start code
+func ParseH265SPS(sps []byte) (VideoInfo, error) {
+	sps = trimStartCode(sps)
+	if len(sps) < 3 || (sps[0]>>1)&0x3F != 33 {
+		return VideoInfo{}, errors.New("not a h265 sps")
+	}
+	r := &bitReader{data: removeEmulationPrevention(sps[2:])}
+	info := VideoInfo{Codec: H265}
+	r.skip(4) //sps_video_parameter_set_id
+	maxSubLayersMinus1 := int(r.u(3))
+	r.skip(1)
+	info.Profile, info.Level = parseH265ProfileTierLevel(r, maxSubLayersMinus1)
+	r.ue() //sps_seq_parameter_set_id
+	info.ChromaFormat = int(r.ue())
+	separatePlanes := false
+	if info.ChromaFormat == 3 {
+		separatePlanes = r.flag()
+	}
+	info.Width = int(r.ue())
+	info.Height = int(r.ue())
+	if r.flag() { //conformance_window_flag
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		subWidth, subHeight := chromaSubsampling(info.ChromaFormat, separatePlanes)
+		info.Width -= subWidth * (left + right)
+		info.Height -= subHeight * (top + bottom)
+	}
+	info.BitDepth = int(r.ue()) + 8
+	r.ue() //bit_depth_chroma_minus8
+	log2MaxPocLsb := int(r.ue()) + 4
+	first := maxSubLayersMinus1
+	if r.flag() { //sps_sub_layer_ordering_info_present_flag
+		first = 0
+	}
+	for i := first; i <= maxSubLayersMinus1; i++ {
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	if r.err != nil {
+		return VideoInfo{}, r.err
+	}
+	//the frame rate is in the vui at the end
+	for i := 0; i < 6; i++ {
+		r.ue() //coding block, transform block and hierarchy depths
+	}
+	if r.flag() && r.flag() { //scaling_list_enabled_flag, sps_scaling_list_data_present_flag
+		skipH265ScalingListData(r)
+	}
+	r.skip(2)     //amp_enabled_flag, sample_adaptive_offset_enabled_flag
+	if r.flag() { //pcm_enabled_flag
+		r.skip(8)
+		r.ue()
+		r.ue()
+		r.skip(1)
+	}
+	sets := int(r.ue())
+	if sets > 64 {
+		return info, nil
+	}
+	numDeltaPocs := make([]int, sets)
+	for i := 0; i < sets && r.err == nil; i++ {
+		numDeltaPocs[i] = skipH265ShortTermRefPicSet(r, i, numDeltaPocs)
+	}
+	if r.flag() { //long_term_ref_pics_present_flag
+		count := int(r.ue())
+		for i := 0; i < count && r.err == nil; i++ {
+			r.skip(log2MaxPocLsb + 1)
+		}
+	}
+	r.skip(2) //sps_temporal_mvp_enabled_flag, strong_intra_smoothing_enabled_flag
+	if r.err == nil && r.flag() {
+		info.FrameRate = parseH265VuiTiming(r)
+	}
+	return info, nil
+}
+
+// h265 E.2.1 up to the timing info, zero if absent or cut
+func parseH265VuiTiming(r *bitReader) float64 {
+	if r.flag() { //aspect_ratio_info_present_flag
+		if r.u(8) == 255 {
+			r.skip(32)
+		}
+	}
+	if r.flag() { //overscan_info_present_flag
+		r.skip(1)
+	}
+	if r.flag() { //video_signal_type_present_flag
+		r.skip(4)
+		if r.flag() {
+			r.skip(24)
+		}
+	}
+	if r.flag() { //chroma_loc_info_present_flag
+		r.ue()
+		r.ue()
+	}
+	r.skip(3)     //neutral_chroma, field_seq and frame_field_info_present flags
+	if r.flag() { //default_display_window_flag
+		r.ue()
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	if !r.flag() {
+		return 0
+	}
+	units := r.u(32)
+	scale := r.u(32)
+	if r.err != nil || units == 0 {
+		return 0
+	}
+	return float64(scale) / float64(units)
+}
+
+// ParseH265VPS reads the profile, the level and the frame rate of a vps nal
+// unit, h265 7.3.2.1
+func ParseH265VPS(vps []byte) (VideoInfo, error) {
+	vps = trimStartCode(vps)
+	if len(vps) < 3 || (vps[0]>>1)&0x3F != 32 {
+		return VideoInfo{}, errors.New("not a h265 vps")
+	}
+	r := &bitReader{data: removeEmulationPrevention(vps[2:])}
+	info := VideoInfo{Codec: H265}
+	r.skip(12) //vps_video_parameter_set_id, base layer flags, vps_max_layers_minus1
+	maxSubLayersMinus1 := int(r.u(3))
+	r.skip(17) //vps_temporal_id_nesting_flag, vps_reserved_0xffff_16bits
+	info.Profile, info.Level = parseH265ProfileTierLevel(r, maxSubLayersMinus1)
+	first := maxSubLayersMinus1
+	if r.flag() {
+		first = 0
+	}
+	for i := first; i <= maxSubLayersMinus1; i++ {
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	maxLayerId := int(r.u(6))
+	layerSets := int(r.ue())
+	if layerSets > 1023 {
+		return VideoInfo{}, errors.New("wrong vps_num_layer_sets_minus1")
+	}
+	r.skip(layerSets * (maxLayerId + 1))
+	if r.err != nil {
+		return VideoInfo{}, r.err
+	}
+	if r.flag() { //vps_timing_info_present_flag
+		units := r.u(32)
+		scale := r.u(32)
+		if r.err == nil && units > 0 {
+			info.FrameRate = float64(scale) / float64(units)
+		}
+	}
+	return info, nil
+}
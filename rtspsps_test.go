@@ -0,0 +1,91 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func decodeSprop(t *testing.T, sprop string) []byte {
+	t.Helper()
+	nalu, err := base64.StdEncoding.DecodeString(sprop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nalu
+}
+
+// sprop-parameter-sets, sprop-vps and sprop-sps of camera sdps
+var spsTests = []struct {
+	name  string
+	sprop string
+	parse func([]byte) (VideoInfo, error)
+	want  VideoInfo
+}{
+	{"h264 baseline 640x480", "Z0IAHqtAUB7TUBAQFAAAAwAEAAADAMoQ", ParseH264SPS,
+		VideoInfo{Codec: H264, Width: 640, Height: 480, Profile: 66, Level: 30, ChromaFormat: 1, BitDepth: 8, FrameRate: 25}},
+	{"h264 main 1080p hikvision", "Z00AKp2oHgCJ+WbgICAoAAADAAgAAAMBlCA=", ParseH264SPS,
+		VideoInfo{Codec: H264, Width: 1920, Height: 1080, Profile: 77, Level: 42, ChromaFormat: 1, BitDepth: 8, FrameRate: 25}},
+	{"h264 high 1080p dahua", "Z2QAKKwbGoB4AiflwFuAgICgAAB9AAAOph0MAHz4AAjDeu8uNDAB8+AAIw3rvLhQAA==", ParseH264SPS,
+		VideoInfo{Codec: H264, Width: 1920, Height: 1080, Profile: 100, Level: 40, ChromaFormat: 1, BitDepth: 8, FrameRate: 15}},
+	{"h265 main 1080p hikvision", "QgEBAWAAAAMAkAAAAwAAAwB4oAPAgBDllmZpJMrgEAAAAwAQAAADAZQg", ParseH265SPS,
+		VideoInfo{Codec: H265, Width: 1920, Height: 1080, Profile: 1, Level: 120, ChromaFormat: 1, BitDepth: 8, FrameRate: 25}},
+	//the sps above with general_profile_idc and the bit depths of main10
+	{"h265 main10 1080p", "QgEBAiAAAAMAkAAAAwAAAwB4oAPAgBDk2WZmkkyuAQAAAwABAAADABlC", ParseH265SPS,
+		VideoInfo{Codec: H265, Width: 1920, Height: 1080, Profile: 2, Level: 120, ChromaFormat: 1, BitDepth: 10, FrameRate: 25}},
+	//no timing info, the frame rate is in the sps
+	{"h265 vps hikvision", "QAEMAf//AWAAAAMAkAAAAwAAAwB4mZgJ", ParseH265VPS,
+		VideoInfo{Codec: H265, Profile: 1, Level: 120}},
+}
+
+func TestParseParameterSets(t *testing.T) {
+	for _, tt := range spsTests {
+		nalu := decodeSprop(t, tt.sprop)
+		for _, prefix := range [][]byte{nil, {0, 0, 1}, {0, 0, 0, 1}} {
+			info, err := tt.parse(append(prefix, nalu...))
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if info != tt.want {
+				t.Errorf("%s: got %+v, want %+v", tt.name, info, tt.want)
+			}
+		}
+	}
+}
+
+func TestParseParameterSetsTruncated(t *testing.T) {
+	for _, tt := range spsTests {
+		nalu := decodeSprop(t, tt.sprop)
+		for n := 0; n < len(nalu); n++ {
+			//a cut set never gives wrong dimensions, only an error or a missing frame rate
+			info, err := tt.parse(nalu[:n])
+			if n < 6 && err == nil {
+				t.Errorf("%s cut to %d bytes: no error", tt.name, n)
+			}
+			if err == nil && (info.Width != tt.want.Width || info.Height != tt.want.Height) {
+				t.Errorf("%s cut to %d bytes: %dx%d", tt.name, n, info.Width, info.Height)
+			}
+		}
+	}
+}
+
+func TestParseParameterSetsMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		nalu  []byte
+		parse func([]byte) (VideoInfo, error)
+	}{
+		{"pps as h264 sps", []byte{0x68, 0xEE, 0x3C, 0x80}, ParseH264SPS},
+		{"h265 sps as h264 sps", []byte{0x42, 0x01, 0x01, 0x01, 0x60}, ParseH264SPS},
+		{"h264 sps as h265 sps", []byte{0x67, 0x42, 0x00, 0x1E, 0xAB}, ParseH265SPS},
+		{"h265 sps as vps", []byte{0x42, 0x01, 0x01, 0x01, 0x60}, ParseH265VPS},
+		//exp-golomb codes longer than 32 bits
+		{"h264 zeros", append([]byte{0x67, 0x42, 0x00, 0x1E}, make([]byte, 16)...), ParseH264SPS},
+		{"h265 zeros", append([]byte{0x42, 0x01}, make([]byte, 32)...), ParseH265SPS},
+		{"h265 vps zeros", append([]byte{0x40, 0x01}, make([]byte, 32)...), ParseH265VPS},
+		{"start code only", []byte{0, 0, 0, 1}, ParseH264SPS},
+	}
+	for _, tt := range tests {
+		if info, err := tt.parse(tt.nalu); err == nil {
+			t.Errorf("%s: got %+v", tt.name, info)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package rtsp
+
+import (
+	"time"
+)
+
+const rateWindow = time.Second
+
+// TrackStats are the counters of a track since the client started, they go on
+// across reconnects
+type TrackStats struct {
+	Track            int
+	Media            string
+	Codec            Codec
+	Ssrc             uint32
+	Bytes            uint64 //of the rtp packets, headers included
+	Packets          uint64
+	Bitrate          float64 //bits per second over the last second
+	Frames           uint64
+	KeyFrames        uint64
+	FrameRate        float64       //frames per second over the last second
+	KeyFrameInterval time.Duration //between the last two key frames
+	PacketsLost      int64         //expected minus received, duplicates make it smaller
+	OutOfOrder       uint64
+	Jitter           time.Duration //rfc3550 interarrival jitter
+	//from the DLRR answer to the receiver reports sent with KeepAliveRtcp,
+	//zero if the server does not support rfc3611
+	RTT time.Duration
+}
+
+type Stats struct {
+	State            State
+	Tracks           []TrackStats
+	Reconnects       int
+	TimeToFirstFrame time.Duration //zero before the first frame
+	DroppedFrames    uint64        //by the queue of Frames
+}
+
+// trackCounters are kept by statsMtx
+type trackCounters struct {
+	bytes        uint64
+	packets      uint64
+	frames       uint64
+	keyFrames    uint64
+	lostBefore   int64 //in the sessions before a reconnect
+	outOfOrder   uint64
+	jitter       float64 //in clock rate units
+	lastKey      time.Time
+	keyInterval  time.Duration
+	rtt          time.Duration
+	windowStart  time.Time
+	windowBytes  uint64
+	windowFrames uint64
+	bitrate      float64
+	frameRate    float64
+}
+
+func (t *trackCounters) roll(now time.Time) {
+	if t.windowStart.IsZero() {
+		t.windowStart = now
+		return
+	}
+	elapsed := now.Sub(t.windowStart)
+	if elapsed < rateWindow {
+		return
+	}
+	t.bitrate, t.frameRate = t.rates(elapsed)
+	t.windowStart = now
+	t.windowBytes = t.bytes
+	t.windowFrames = t.frames
+}
+
+func (t *trackCounters) rates(elapsed time.Duration) (float64, float64) {
+	seconds := elapsed.Seconds()
+	return float64(t.bytes-t.windowBytes) * 8 / seconds, float64(t.frames-t.windowFrames) / seconds
+}
+
+// lost is rfc3550 A.3 for the current session
+func (m *meidaTransport) lost() int64 {
+	if !m.received {
+		return 0
+	}
+	expected := int64(m.highestSeq-m.baseSeq) + 1
+	return expected - int64(m.packetsReceived)
+}
+
+// rfc3550 A.8, the arrival time is counted in clock rate units from the first packet
+func (m *meidaTransport) updateJitter(timestamp uint32, now time.Time) {
+	if m.clockRate <= 0 {
+		return
+	}
+	if m.arrivalBase.IsZero() {
+		m.arrivalBase = now
+	}
+	arrival := uint32(int64(now.Sub(m.arrivalBase).Seconds() * float64(m.clockRate)))
+	transit := arrival - timestamp
+	if m.hasTransit {
+		d := float64(int32(transit - m.transit))
+		if d < 0 {
+			d = -d
+		}
+		m.stats.jitter += (d - m.stats.jitter) / 16
+	}
+	m.transit = transit
+	m.hasTransit = true
+}
+
+func (c *Rtspclient) handleRtcp(track int, packet []byte, now time.Time) {
+	packets, err := decodeRtcp(packet)
+	if err != nil {
+		c.logDebug("wrong rtcp packet", "error", err, "track", track)
+	}
+	c.statsMtx.Lock()
+	defer c.statsMtx.Unlock()
+	media := &c.mediaChanel[track]
+	for _, p := range packets {
+		if p.pt == RTCP_SR {
+			media.lsr = ntpShort(p.ntp)
+			media.lsrTime = now
+		}
+		for _, block := range p.blocks {
+			if block.ssrc != c.rtcpSsrc {
+				continue
+			}
+			if rtt, ok := roundTrip(now, block.lsr, block.dlsr); ok {
+				media.stats.rtt = rtt
+			}
+		}
+		for _, block := range p.dlrr {
+			if block.ssrc != c.rtcpSsrc {
+				continue
+			}
+			if rtt, ok := roundTrip(now, block.lrr, block.dlrr); ok {
+				media.stats.rtt = rtt
+			}
+		}
+	}
+}
+
+func (c *Rtspclient) countFrame(track int, frame Frame) {
+	now := time.Now()
+	c.statsMtx.Lock()
+	defer c.statsMtx.Unlock()
+	if c.firstFrame.IsZero() {
+		c.firstFrame = now
+	}
+	stats := &c.mediaChanel[track].stats
+	stats.frames++
+	if frame.IsKey && (frame.Cid == H264 || frame.Cid == H265) {
+		stats.keyFrames++
+		if !stats.lastKey.IsZero() {
+			stats.keyInterval = now.Sub(stats.lastKey)
+		}
+		stats.lastKey = now
+	}
+}
+
+// carryStats keeps the counters of the tracks when a reconnect describes them again
+func carryStats(old []meidaTransport, medias []meidaTransport) {
+	for i := range medias {
+		if i >= len(old) || old[i].media != medias[i].media {
+			continue
+		}
+		medias[i].stats = old[i].stats
+		medias[i].stats.lostBefore += old[i].lost()
+	}
+}
+
+// markStart begins the time to the first frame
+func (c *Rtspclient) markStart() {
+	c.statsMtx.Lock()
+	defer c.statsMtx.Unlock()
+	if c.startTime.IsZero() {
+		c.startTime = time.Now()
+	}
+}
+
+// Stats is a snapshot of the counters of every received track
+func (c *Rtspclient) Stats() Stats {
+	now := time.Now()
+	stats := Stats{DroppedFrames: c.DroppedFrames()}
+	c.mtx.Lock()
+	stats.State = c.state
+	stats.Reconnects = c.reconnects
+	c.mtx.Unlock()
+
+	c.statsMtx.Lock()
+	defer c.statsMtx.Unlock()
+	if !c.firstFrame.IsZero() && !c.startTime.IsZero() {
+		stats.TimeToFirstFrame = c.firstFrame.Sub(c.startTime)
+	}
+	//by pointer, the receive goroutine writes the other fields without the lock,
+	//only stats and the fields of updateSeq are read
+	for i := range c.mediaChanel {
+		media := &c.mediaChanel[i]
+		if media.backchannel {
+			continue
+		}
+		counters := media.stats
+		bitrate, frameRate := counters.bitrate, counters.frameRate
+		//the stream stalled, nothing rolled the window
+		if elapsed := now.Sub(counters.windowStart); !counters.windowStart.IsZero() && elapsed > rateWindow {
+			bitrate, frameRate = counters.rates(elapsed)
+		}
+		track := TrackStats{
+			Track:            i,
+			Media:            media.media,
+			Codec:            media.codec,
+			Ssrc:             media.ssrc,
+			Bytes:            counters.bytes,
+			Packets:          counters.packets,
+			Bitrate:          bitrate,
+			Frames:           counters.frames,
+			KeyFrames:        counters.keyFrames,
+			FrameRate:        frameRate,
+			KeyFrameInterval: counters.keyInterval,
+			PacketsLost:      counters.lostBefore + media.lost(),
+			OutOfOrder:       counters.outOfOrder,
+			RTT:              counters.rtt,
+		}
+		if media.clockRate > 0 {
+			track.Jitter = time.Duration(counters.jitter / float64(media.clockRate) * float64(time.Second))
+		}
+		stats.Tracks = append(stats.Tracks, track)
+	}
+	return stats
+}
@@ -0,0 +1,276 @@
+package rtsp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Transport is one alternative of the Transport header, rfc2326 12.39 and rfc7826 18.54
+// Transport: RTP/AVP/TCP;unicast;interleaved=0-1;ssrc=6D3A5F3B;mode="PLAY"
+type Transport struct {
+	Protocol       string //RTP
+	Profile        string //AVP, SAVP, AVPF, SAVPF
+	Lower          string //TCP or UDP, empty means UDP
+	Unicast        bool
+	Multicast      bool
+	Destination    string
+	Source         string
+	Interleaved    [2]int
+	HasInterleaved bool
+	Append         bool
+	TTL            int
+	Layers         int
+	Port           [2]int //multicast, zero if absent
+	ClientPort     [2]int
+	ServerPort     [2]int
+	SSRC           string
+	Mode           string //PLAY or RECORD
+	DestAddr       []string
+	SrcAddr        []string
+	RtcpMux        bool
+	//parameters this type does not know, kept for marshal
+	Extra []string
+}
+
+func (t Transport) IsTcp() bool {
+	return strings.EqualFold(t.Lower, "TCP")
+}
+
+func parsePair(value string) ([2]int, error) {
+	var pair [2]int
+	elems := strings.SplitN(value, "-", 2)
+	var err error
+	if pair[0], err = strconv.Atoi(strings.TrimSpace(elems[0])); err != nil {
+		return pair, errors.New("wrong range value " + value)
+	}
+	if len(elems) == 2 {
+		if pair[1], err = strconv.Atoi(strings.TrimSpace(elems[1])); err != nil {
+			return pair, errors.New("wrong range value " + value)
+		}
+	} else {
+		pair[1] = pair[0]
+	}
+	return pair, nil
+}
+
+func formatPair(pair [2]int) string {
+	if pair[1] == pair[0] {
+		return strconv.Itoa(pair[0])
+	}
+	return strconv.Itoa(pair[0]) + "-" + strconv.Itoa(pair[1])
+}
+
+// dest_addr="192.0.2.5:3456"/"192.0.2.5:3457"
+func parseAddrList(value string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(value, "/") {
+		addrs = append(addrs, strings.Trim(strings.TrimSpace(addr), "\""))
+	}
+	return addrs
+}
+
+func formatAddrList(addrs []string) string {
+	quoted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		quoted[i] = "\"" + addr + "\""
+	}
+	return strings.Join(quoted, "/")
+}
+
+func ParseTransport(transport string) (Transport, error) {
+	var t Transport
+	params := strings.Split(strings.TrimSpace(transport), ";")
+	spec := strings.Split(strings.TrimSpace(params[0]), "/")
+	if len(spec) < 2 {
+		return t, errors.New("wrong transport spec " + params[0])
+	}
+	t.Protocol = strings.ToUpper(spec[0])
+	t.Profile = strings.ToUpper(spec[1])
+	if len(spec) > 2 {
+		t.Lower = strings.ToUpper(spec[2])
+	}
+	var err error
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		kv := strings.SplitN(param, "=", 2)
+		name := strings.ToLower(kv[0])
+		var value string
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+		switch name {
+		case "unicast":
+			t.Unicast = true
+		case "multicast":
+			t.Multicast = true
+		case "append":
+			t.Append = true
+		case "rtcp-mux":
+			t.RtcpMux = true
+		case "destination":
+			t.Destination = value
+		case "source":
+			t.Source = value
+		case "interleaved":
+			if t.Interleaved, err = parsePair(value); err != nil {
+				return t, err
+			}
+			t.HasInterleaved = true
+		case "ttl":
+			if t.TTL, err = strconv.Atoi(value); err != nil {
+				return t, errors.New("wrong transport ttl " + value)
+			}
+		case "layers":
+			if t.Layers, err = strconv.Atoi(value); err != nil {
+				return t, errors.New("wrong transport layers " + value)
+			}
+		case "port":
+			if t.Port, err = parsePair(value); err != nil {
+				return t, err
+			}
+		case "client_port":
+			if t.ClientPort, err = parsePair(value); err != nil {
+				return t, err
+			}
+		case "server_port":
+			if t.ServerPort, err = parsePair(value); err != nil {
+				return t, err
+			}
+		case "ssrc":
+			t.SSRC = value
+		case "mode":
+			t.Mode = strings.ToUpper(strings.Trim(value, "\""))
+		case "dest_addr":
+			t.DestAddr = parseAddrList(value)
+		case "src_addr":
+			t.SrcAddr = parseAddrList(value)
+		default:
+			t.Extra = append(t.Extra, param)
+		}
+	}
+	return t, nil
+}
+
+func (t Transport) String() string {
+	var sb strings.Builder
+	sb.WriteString(t.Protocol + "/" + t.Profile)
+	if t.Lower != "" {
+		sb.WriteString("/" + t.Lower)
+	}
+	if t.Unicast {
+		sb.WriteString(";unicast")
+	}
+	if t.Multicast {
+		sb.WriteString(";multicast")
+	}
+	if t.Destination != "" {
+		sb.WriteString(";destination=" + t.Destination)
+	}
+	if t.Source != "" {
+		sb.WriteString(";source=" + t.Source)
+	}
+	if t.HasInterleaved {
+		sb.WriteString(";interleaved=" + formatPair(t.Interleaved))
+	}
+	if t.Append {
+		sb.WriteString(";append")
+	}
+	if t.TTL > 0 {
+		sb.WriteString(";ttl=" + strconv.Itoa(t.TTL))
+	}
+	if t.Layers > 0 {
+		sb.WriteString(";layers=" + strconv.Itoa(t.Layers))
+	}
+	if t.Port[0] > 0 {
+		sb.WriteString(";port=" + formatPair(t.Port))
+	}
+	if t.ClientPort[0] > 0 {
+		sb.WriteString(";client_port=" + formatPair(t.ClientPort))
+	}
+	if t.ServerPort[0] > 0 {
+		sb.WriteString(";server_port=" + formatPair(t.ServerPort))
+	}
+	if len(t.DestAddr) > 0 {
+		sb.WriteString(";dest_addr=" + formatAddrList(t.DestAddr))
+	}
+	if len(t.SrcAddr) > 0 {
+		sb.WriteString(";src_addr=" + formatAddrList(t.SrcAddr))
+	}
+	if t.RtcpMux {
+		sb.WriteString(";RTCP-mux")
+	}
+	if t.SSRC != "" {
+		sb.WriteString(";ssrc=" + t.SSRC)
+	}
+	if t.Mode != "" {
+		sb.WriteString(";mode=" + t.Mode)
+	}
+	for _, extra := range t.Extra {
+		sb.WriteString(";" + extra)
+	}
+	return sb.String()
+}
+
+// Transports are the alternatives of a Transport header in order of preference
+type Transports []Transport
+
+// split at commas outside of quoted strings
+func splitList(list string) []string {
+	var elems []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				elems = append(elems, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(elems, list[start:])
+}
+
+func ParseTransports(transports string) (Transports, error) {
+	var result Transports
+	for _, elem := range splitList(transports) {
+		if strings.TrimSpace(elem) == "" {
+			continue
+		}
+		t, err := ParseTransport(elem)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("empty transport")
+	}
+	return result, nil
+}
+
+func (ts Transports) String() string {
+	elems := make([]string, len(ts))
+	for i, t := range ts {
+		elems[i] = t.String()
+	}
+	return strings.Join(elems, ",")
+}
+
+func NewTcpTransport(channel int, mode string) Transport {
+	return Transport{
+		Protocol:       "RTP",
+		Profile:        "AVP",
+		Lower:          "TCP",
+		Unicast:        true,
+		Interleaved:    [2]int{channel, channel + 1},
+		HasInterleaved: true,
+		Mode:           mode,
+	}
+}
@@ -0,0 +1,99 @@
+package rtsp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTransport(t *testing.T) {
+	tests := []struct {
+		header string
+		want   Transport
+		str    string //of want, the header if empty
+	}{
+		{"RTP/AVP/TCP;unicast;interleaved=0-1",
+			Transport{Protocol: "RTP", Profile: "AVP", Lower: "TCP", Unicast: true, Interleaved: [2]int{0, 1}, HasInterleaved: true}, ""},
+		{"RTP/AVP;unicast;client_port=8000-8001;server_port=9000-9001;ssrc=6D3A5F3B;mode=PLAY",
+			Transport{Protocol: "RTP", Profile: "AVP", Unicast: true, ClientPort: [2]int{8000, 8001}, ServerPort: [2]int{9000, 9001},
+				SSRC: "6D3A5F3B", Mode: "PLAY"}, ""},
+		{`rtp/avp/udp; Unicast; client_port=8000-8001; mode="play"`,
+			Transport{Protocol: "RTP", Profile: "AVP", Lower: "UDP", Unicast: true, ClientPort: [2]int{8000, 8001}, Mode: "PLAY"},
+			"RTP/AVP/UDP;unicast;client_port=8000-8001;mode=PLAY"},
+		{"RTP/AVP;multicast;destination=224.2.0.1;port=3456-3457;ttl=16;layers=2",
+			Transport{Protocol: "RTP", Profile: "AVP", Multicast: true, Destination: "224.2.0.1", Port: [2]int{3456, 3457}, TTL: 16, Layers: 2},
+			"RTP/AVP;multicast;destination=224.2.0.1;ttl=16;layers=2;port=3456-3457"},
+		{"RTP/AVP/TCP;interleaved=4;append;mode=RECORD",
+			Transport{Protocol: "RTP", Profile: "AVP", Lower: "TCP", Interleaved: [2]int{4, 4}, HasInterleaved: true, Append: true, Mode: "RECORD"}, ""},
+		//rtsp 2.0
+		{`RTP/SAVPF;unicast;dest_addr="192.0.2.5:3456"/"192.0.2.5:3457";src_addr="198.51.100.1:6000";RTCP-mux;MIKEY=abc`,
+			Transport{Protocol: "RTP", Profile: "SAVPF", Unicast: true, DestAddr: []string{"192.0.2.5:3456", "192.0.2.5:3457"},
+				SrcAddr: []string{"198.51.100.1:6000"}, RtcpMux: true, Extra: []string{"MIKEY=abc"}}, ""},
+		{"RTP/AVP;unicast;source=10.0.0.1;x-dynamic-rate=1;;",
+			Transport{Protocol: "RTP", Profile: "AVP", Unicast: true, Source: "10.0.0.1", Extra: []string{"x-dynamic-rate=1"}},
+			"RTP/AVP;unicast;source=10.0.0.1;x-dynamic-rate=1"},
+	}
+	for _, tt := range tests {
+		got, err := ParseTransport(tt.header)
+		if err != nil {
+			t.Errorf("%s: %v", tt.header, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.header, got, tt.want)
+		}
+		str := tt.str
+		if str == "" {
+			str = tt.header
+		}
+		if got.String() != str {
+			t.Errorf("%s: String is %s, want %s", tt.header, got.String(), str)
+		}
+		if again, err := ParseTransport(got.String()); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("%s: %s parses to %+v %v", tt.header, got.String(), again, err)
+		}
+	}
+}
+
+func TestParseTransportMalformed(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"RTP",
+		"RTP/AVP;interleaved=a-b",
+		"RTP/AVP;interleaved=0-b",
+		"RTP/AVP;client_port=",
+		"RTP/AVP;server_port=9000-x",
+		"RTP/AVP;port=x",
+		"RTP/AVP;ttl=high",
+		"RTP/AVP;layers=two",
+	} {
+		if transport, err := ParseTransport(header); err == nil {
+			t.Errorf("%q: got %+v", header, transport)
+		}
+	}
+}
+
+func TestParseTransports(t *testing.T) {
+	header := `RTP/AVP/TCP;unicast;interleaved=0-1,RTP/AVP;unicast;dest_addr="a,b:1"/"c:2",RTP/AVP;unicast;client_port=8000-8001`
+	transports, err := ParseTransports(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transports) != 3 || !transports[0].IsTcp() || transports[1].IsTcp() ||
+		!reflect.DeepEqual(transports[1].DestAddr, []string{"a,b:1", "c:2"}) || transports[2].ClientPort != [2]int{8000, 8001} {
+		t.Errorf("got %+v", transports)
+	}
+	if transports.String() != header {
+		t.Errorf("String is %s", transports.String())
+	}
+	for _, header := range []string{"", " , ", "RTP/AVP/TCP;interleaved=0-1,RTP"} {
+		if _, err := ParseTransports(header); err == nil {
+			t.Errorf("%q: no error", header)
+		}
+	}
+}
+
+func TestNewTcpTransport(t *testing.T) {
+	if got := NewTcpTransport(2, "PLAY").String(); got != "RTP/AVP/TCP;unicast;interleaved=2-3;mode=PLAY" {
+		t.Errorf("got %s", got)
+	}
+}